@@ -0,0 +1,329 @@
+// Tideland Go Network - JWT Tool
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package main
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// COMMANDS
+//--------------------
+
+// environment contains the streams the commands work with.
+type environment struct {
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+// decode prints header, claims, and validity of a token without
+// verifying it.
+func (env *environment) decode(args []string) error {
+	fs := env.flagSet("decode", "[token]")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	st, err := env.input(fs.Args())
+	if err != nil {
+		return err
+	}
+	jwt, err := token.Decode(st)
+	if err != nil {
+		return err
+	}
+	return env.print(jwt)
+}
+
+// verify verifies the signature of a token with the key of a file
+// and checks its validity.
+func (env *environment) verify(args []string) error {
+	fs := env.flagSet("verify", "[token]")
+	keyFile := fs.String("key", "", "file containing the verification key")
+	leeway := fs.Duration("leeway", time.Minute, "leeway for the validity check")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *keyFile == "" {
+		return failure.New("missing key file")
+	}
+	st, err := env.input(fs.Args())
+	if err != nil {
+		return err
+	}
+	key, set, err := readKeyFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	var jwt *token.JWT
+	if set != nil {
+		jwt, err = token.VerifyWithKeyFunc(st, set.KeyFor)
+	} else {
+		if key, err = token.PublicKey(key); err != nil {
+			return err
+		}
+		jwt, err = token.Verify(st, key)
+	}
+	if err != nil {
+		return err
+	}
+	if err = env.print(jwt); err != nil {
+		return err
+	}
+	if !jwt.IsValid(*leeway) {
+		return failure.New("token is not valid at the current time")
+	}
+	fmt.Fprintln(env.stdout, "Signature and validity are fine.")
+	return nil
+}
+
+// encode creates a signed token out of JSON claims.
+func (env *environment) encode(args []string) error {
+	fs := env.flagSet("encode", "[claims]")
+	alg := fs.String("alg", "", "signing algorithm")
+	keyFile := fs.String("key", "", "file containing the signing key")
+	kid := fs.String("kid", "", "key ID of the header")
+	iat := fs.Bool("iat", false, "set the 'iat' claim to now")
+	exp := fs.Duration("exp", 0, "set the 'exp' claim to now plus duration")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	algorithm := token.Algorithm(*alg)
+	var key token.Key
+	switch {
+	case algorithm == "":
+		return failure.New("missing algorithm")
+	case algorithm == token.NONE:
+		key = ""
+	case *keyFile == "":
+		return failure.New("missing key file")
+	default:
+		var set *token.JWKSet
+		var err error
+		if key, set, err = readKeyFile(*keyFile); err != nil {
+			return err
+		}
+		if set != nil {
+			return failure.New("cannot sign with a JWK set")
+		}
+	}
+	input, err := env.input(fs.Args())
+	if err != nil {
+		return err
+	}
+	claims := token.NewClaims()
+	if err = json.Unmarshal([]byte(input), &claims); err != nil {
+		return failure.Annotate(err, "cannot unmarshal the claims")
+	}
+	now := time.Now()
+	if *iat {
+		claims.SetIssuedAt(now)
+	}
+	if *exp > 0 {
+		claims.SetExpiration(now.Add(*exp))
+	}
+	jwt, err := token.EncodeWithHeader(claims, key, token.Header{
+		Algorithm: algorithm,
+		Type:      "JWT",
+		KeyID:     *kid,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(env.stdout, jwt.String())
+	return nil
+}
+
+// keygen generates a key for an algorithm. The private key is written
+// PEM encoded, the public key PEM or JWK encoded. HMAC secrets are
+// always written as JWK.
+func (env *environment) keygen(args []string) error {
+	fs := env.flagSet("keygen", "")
+	alg := fs.String("alg", "", "algorithm the key is used for")
+	out := fs.String("out", "", "file for the private key, default stdout")
+	pub := fs.String("pub", "", "file for the public key")
+	asJWK := fs.Bool("jwk", false, "write the public key as JWK")
+	kid := fs.String("kid", "", "key ID of JWKs")
+	if err := fs.Parse(args); err != nil {
+		return flagError(err)
+	}
+	algorithm := token.Algorithm(*alg)
+	if algorithm == "" || algorithm == token.NONE {
+		return failure.New("missing algorithm")
+	}
+	key, err := token.GenerateKey(algorithm)
+	if err != nil {
+		return err
+	}
+	_, isSecret := key.([]byte)
+	if isSecret && *pub != "" {
+		return failure.New("HMAC secrets have no public key")
+	}
+	jwk := func(key token.Key) ([]byte, error) {
+		jwk, err := token.NewJWK(key)
+		if err != nil {
+			return nil, err
+		}
+		jwk.KeyID = *kid
+		jwk.Use = "sig"
+		jwk.Algorithm = string(algorithm)
+		b, err := json.MarshalIndent(jwk, "", "  ")
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot marshal the JWK")
+		}
+		return append(b, '\n'), nil
+	}
+	// Write the private key.
+	var private []byte
+	if isSecret {
+		private, err = jwk(key)
+	} else {
+		private, err = pemKey(key, token.PKCS8)
+	}
+	if err != nil {
+		return err
+	}
+	if err = env.output(*out, private); err != nil {
+		return err
+	}
+	// Write the public key.
+	if *pub == "" {
+		return nil
+	}
+	var public []byte
+	if *asJWK {
+		public, err = jwk(key)
+	} else {
+		var publicKey token.Key
+		if publicKey, err = token.PublicKey(key); err == nil {
+			public, err = pemKey(publicKey, token.PKIX)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return env.output(*pub, public)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// flagSet creates the flag set for a command.
+func (env *environment) flagSet(command, arguments string) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(env.stdout)
+	fs.Usage = func() {
+		fmt.Fprintf(env.stdout, "usage: jwt %s [flags] %s\n", command, arguments)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// flagError hides the request for help as error.
+func flagError(err error) error {
+	if err == flag.ErrHelp {
+		return nil
+	}
+	return err
+}
+
+// input returns the trimmed argument or, if missing or "-",
+// the content of stdin.
+func (env *environment) input(args []string) (string, error) {
+	switch {
+	case len(args) > 1:
+		return "", failure.New("too many arguments")
+	case len(args) == 1 && args[0] != "-":
+		return strings.TrimSpace(args[0]), nil
+	}
+	b, err := ioutil.ReadAll(env.stdin)
+	if err != nil {
+		return "", failure.Annotate(err, "cannot read stdin")
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// output writes the data into the file or, if empty, to stdout.
+func (env *environment) output(filename string, data []byte) error {
+	if filename == "" {
+		_, err := env.stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		return failure.Annotate(err, "cannot write file '%s'", filename)
+	}
+	return nil
+}
+
+// print writes header, claims, and validity of the token.
+func (env *environment) print(jwt *token.JWT) error {
+	header, err := json.MarshalIndent(jwt.Header(), "", "  ")
+	if err != nil {
+		return failure.Annotate(err, "cannot marshal the header")
+	}
+	claims, err := json.MarshalIndent(jwt.Claims(), "", "  ")
+	if err != nil {
+		return failure.Annotate(err, "cannot marshal the claims")
+	}
+	fmt.Fprintf(env.stdout, "Header:\n%s\n\nClaims:\n%s\n\nValidity:\n", header, claims)
+	now := time.Now()
+	times := []struct {
+		label string
+		get   func() (time.Time, bool)
+	}{
+		{"issued at ", jwt.Claims().IssuedAt},
+		{"not before", jwt.Claims().NotBefore},
+		{"expires   ", jwt.Claims().Expiration},
+	}
+	for _, t := range times {
+		if at, ok := t.get(); ok {
+			fmt.Fprintf(env.stdout, "  %s  %s (%s)\n", t.label, at.Format(time.RFC3339), relative(at, now))
+		}
+	}
+	fmt.Fprintf(env.stdout, "  status      %s\n\n", status(jwt.Claims(), now))
+	return nil
+}
+
+// relative describes the time relative to now.
+func relative(t, now time.Time) string {
+	d := t.Sub(now).Round(time.Second)
+	switch {
+	case d > 0:
+		return "in " + d.String()
+	case d < 0:
+		return (-d).String() + " ago"
+	default:
+		return "now"
+	}
+}
+
+// status describes the validity of the claims at the time.
+func status(claims token.Claims, now time.Time) string {
+	if exp, ok := claims.Expiration(); ok && !now.Before(exp) {
+		return "expired"
+	}
+	if nbf, ok := claims.NotBefore(); ok && now.Before(nbf) {
+		return "not yet valid"
+	}
+	return "valid"
+}
+
+// EOF
@@ -0,0 +1,108 @@
+// Tideland Go Network - JWT Tool
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package main
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// KEYS
+//--------------------
+
+// readKeyFile reads the key of a file. JSON content is read as JWK
+// or, if it contains keys, as JWK set. PEM content is read as key or
+// certificate. All other content is used as HMAC secret without
+// trailing line breaks.
+func readKeyFile(filename string) (token.Key, *token.JWKSet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, nil, failure.Annotate(err, "cannot read key file '%s'", filename)
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var probe struct {
+			Keys json.RawMessage `json:"keys"`
+		}
+		if err = json.Unmarshal(trimmed, &probe); err != nil {
+			return nil, nil, failure.Annotate(err, "cannot unmarshal key file '%s'", filename)
+		}
+		if probe.Keys != nil {
+			set, err := token.ReadJWKSet(bytes.NewReader(trimmed))
+			return nil, set, err
+		}
+		jwk, err := token.ReadJWK(bytes.NewReader(trimmed))
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := jwk.Key()
+		return key, nil, err
+	case bytes.HasPrefix(trimmed, []byte("-----BEGIN")):
+		key, err := parsePEM(trimmed)
+		return key, nil, err
+	default:
+		return bytes.TrimRight(data, "\r\n"), nil, nil
+	}
+}
+
+// parsePEM parses the first PEM block as key or certificate. For
+// certificates their public key is returned.
+func parsePEM(data []byte) (token.Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, failure.New("cannot decode the PEM")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, failure.New("PEM type '%s' is not supported", block.Type)
+	}
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot parse the PEM")
+	}
+	return key, nil
+}
+
+// pemKey returns the PEM encoded key in the format.
+func pemKey(key token.Key, format token.KeyFormat) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := token.WriteKey(&buf, key, format); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EOF
@@ -0,0 +1,84 @@
+// Tideland Go Network - JWT Tool
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+// Command jwt inspects, signs, and verifies JSON Web Tokens and
+// generates keys for them. Tokens and claims are passed as argument
+// or, if missing or "-", read from stdin.
+//
+//	jwt decode [token]
+//	jwt verify -key <file> [-leeway <duration>] [token]
+//	jwt encode -alg <algorithm> -key <file> [-kid <id>] [-iat] [-exp <duration>] [claims]
+//	jwt keygen -alg <algorithm> [-out <file>] [-pub <file>] [-jwk] [-kid <id>]
+//
+// Key files may contain PEM encoded keys or certificates, a JSON Web
+// Key, a JSON Web Key Set, or a plain HMAC secret.
+package main
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// MAIN
+//--------------------
+
+// usage describes the commands of the tool.
+const usage = `usage: jwt <command> [flags] [argument]
+
+commands:
+  decode   print header, claims, and validity of a token
+  verify   verify the signature and validity of a token
+  encode   create a signed token out of JSON claims
+  keygen   generate a key for an algorithm
+
+run 'jwt <command> -h' for the flags of a command
+`
+
+// main runs the tool.
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "jwt: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command of the arguments.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprint(stdout, usage)
+		return failure.New("missing command")
+	}
+	env := &environment{
+		stdin:  stdin,
+		stdout: stdout,
+	}
+	switch args[0] {
+	case "decode":
+		return env.decode(args[1:])
+	case "verify":
+		return env.verify(args[1:])
+	case "encode":
+		return env.encode(args[1:])
+	case "keygen":
+		return env.keygen(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return nil
+	default:
+		return failure.New("unknown command '%s'", args[0])
+	}
+}
+
+// EOF
@@ -0,0 +1,159 @@
+// Tideland Go Network - JWT Tool - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package main
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestKeygenEncodeVerify tests the roundtrip of generating keys,
+// encoding, and verifying tokens.
+func TestKeygenEncodeVerify(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	dir, err := ioutil.TempDir("", "jwt")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tests := []struct {
+		alg  string
+		pub  string
+		args []string
+	}{
+		{"ES256", "es256.pub", nil},
+		{"ES384", "es384.jwk", []string{"-jwk"}},
+		{"EdDSA", "eddsa.pub", nil},
+		{"PS256", "ps256.jwk", []string{"-jwk", "-kid", "p1"}},
+		{"HS512", "", nil},
+	}
+	for _, test := range tests {
+		assert.Logf("testing algorithm %s", test.alg)
+		args := []string{"keygen", "-alg", test.alg, "-out", path(test.alg + ".key")}
+		if test.pub != "" {
+			args = append(args, "-pub", path(test.pub))
+		}
+		_, err := runTest(append(args, test.args...), "")
+		assert.NoError(err)
+		st, err := runTest([]string{"encode", "-alg", test.alg, "-key", path(test.alg + ".key"), "-iat", "-exp", "1h"}, `{"sub":"john"}`)
+		assert.NoError(err)
+		st = strings.TrimSpace(st)
+		verifyKey := test.pub
+		if verifyKey == "" {
+			verifyKey = test.alg + ".key"
+		}
+		out, err := runTest([]string{"verify", "-key", path(verifyKey), st}, "")
+		assert.NoError(err)
+		assert.Substring(`"alg": "`+test.alg+`"`, out)
+		assert.Substring(`"sub": "john"`, out)
+		assert.Substring("status      valid", out)
+		// Verification with a private key works too.
+		_, err = runTest([]string{"verify", "-key", path(test.alg + ".key")}, st)
+		assert.NoError(err)
+	}
+
+	assert.Logf("testing JWK set")
+	jwk, err := ioutil.ReadFile(path("ps256.jwk"))
+	assert.NoError(err)
+	err = ioutil.WriteFile(path("set.json"), []byte(`{"keys":[`+string(jwk)+`]}`), 0600)
+	assert.NoError(err)
+	st, err := runTest([]string{"encode", "-alg", "PS256", "-key", path("PS256.key"), "-kid", "p1", "-"}, `{}`)
+	assert.NoError(err)
+	_, err = runTest([]string{"verify", "-key", path("set.json")}, st)
+	assert.NoError(err)
+	st, err = runTest([]string{"encode", "-alg", "PS256", "-key", path("PS256.key"), "-kid", "p2"}, `{}`)
+	assert.NoError(err)
+	_, err = runTest([]string{"verify", "-key", path("set.json")}, st)
+	assert.ErrorMatch(err, ".*no key with ID 'p2'.*")
+
+	assert.Logf("testing plain HMAC secret")
+	err = ioutil.WriteFile(path("secret"), []byte("0123456789abcdef0123456789abcdef\n"), 0600)
+	assert.NoError(err)
+	st, err = runTest([]string{"encode", "-alg", "HS256", "-key", path("secret")}, `{"exp":1}`)
+	assert.NoError(err)
+	out, err := runTest([]string{"verify", "-key", path("secret")}, st)
+	assert.ErrorMatch(err, ".*token is not valid at the current time.*")
+	assert.Substring("status      expired", out)
+	_, err = runTest([]string{"verify", "-key", path("ES256.key")}, st)
+	assert.ErrorMatch(err, ".*cannot verify the signature.*")
+}
+
+// TestDecode tests the printing of tokens.
+func TestDecode(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	st, err := runTest([]string{"encode", "-alg", "none"}, `{"nbf":4102444800,"iss":"tester"}`)
+	assert.NoError(err)
+	out, err := runTest([]string{"decode", strings.TrimSpace(st)}, "")
+	assert.NoError(err)
+	assert.Substring(`"alg": "none"`, out)
+	assert.Substring(`"iss": "tester"`, out)
+	assert.Substring("not before  2100-01-01T", out)
+	assert.Substring("status      not yet valid", out)
+
+	_, err = runTest([]string{"decode"}, "no token")
+	assert.ErrorMatch(err, ".*cannot decode.*")
+	_, err = runTest([]string{"decode", "a", "b"}, "")
+	assert.ErrorMatch(err, ".*too many arguments.*")
+}
+
+// TestErrors tests the handling of invalid commands and flags.
+func TestErrors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{nil, ".*missing command.*"},
+		{[]string{"unknown"}, ".*unknown command 'unknown'.*"},
+		{[]string{"verify"}, ".*missing key file.*"},
+		{[]string{"encode"}, ".*missing algorithm.*"},
+		{[]string{"encode", "-alg", "HS256"}, ".*missing key file.*"},
+		{[]string{"keygen"}, ".*missing algorithm.*"},
+		{[]string{"keygen", "-alg", "XY256"}, ".*algorithm 'XY256' is invalid.*"},
+		{[]string{"keygen", "-alg", "HS256", "-pub", "secret.pub"}, ".*HMAC secrets have no public key.*"},
+		{[]string{"decode", "-unknown"}, ".*flag provided but not defined.*"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %v", test.args)
+		_, err := runTest(test.args, "")
+		assert.ErrorMatch(err, test.err)
+	}
+	out, err := runTest([]string{"encode", "-h"}, "")
+	assert.NoError(err)
+	assert.Substring("usage: jwt encode", out)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// runTest runs the tool with arguments and stdin and returns
+// its output.
+func runTest(args []string, stdin string) (string, error) {
+	var stdout bytes.Buffer
+	err := run(args, strings.NewReader(stdin), &stdout)
+	return stdout.String(), err
+}
+
+// EOF
@@ -3,6 +3,7 @@ module tideland.dev/go/net
 go 1.13
 
 require (
+	golang.org/x/crypto v0.0.0-20200117160349-530e935923ad
 	tideland.dev/go/audit v0.4.0
 	tideland.dev/go/together v0.0.0-20200110213028-8f143e2737a6
 	tideland.dev/go/trace v0.0.0-20200110203012-77bd5be58e01
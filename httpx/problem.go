@@ -0,0 +1,265 @@
+// Tideland Go Network - HTTP Extensions
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx // import "tideland.dev/go/net/httpx"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Content types of problem details.
+const (
+	ContentTypeProblemJSON = "application/problem+json"
+	ContentTypeProblemXML  = "application/problem+xml"
+)
+
+// problemNamespace is the XML namespace of problem details.
+const problemNamespace = "urn:ietf:rfc:7807"
+
+//--------------------
+// PROBLEM
+//--------------------
+
+// Problem contains the details of an error of an HTTP API as defined
+// in RFC 7807. An empty Type stands for "about:blank", so the problem
+// has no further semantics than the status code. Extensions contain
+// additional members, they cannot replace the standard ones.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+// NewProblem creates a problem for the status code with its text as
+// title and the given detail.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// Error implements the error interface.
+func (p *Problem) Error() string {
+	msg := strconv.Itoa(p.Status) + " " + p.Title
+	if p.Detail != "" {
+		msg += ": " + p.Detail
+	}
+	return msg
+}
+
+// MarshalJSON implements json.Marshaler.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	members := make(map[string]interface{}, len(p.Extensions)+5)
+	for key, value := range p.Extensions {
+		members[key] = value
+	}
+	for key, value := range p.members() {
+		members[key] = value
+	}
+	return json.Marshal(members)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return failure.Annotate(err, "cannot unmarshal problem")
+	}
+	*p = Problem{}
+	fields := map[string]interface{}{
+		"type":     &p.Type,
+		"title":    &p.Title,
+		"status":   &p.Status,
+		"detail":   &p.Detail,
+		"instance": &p.Instance,
+	}
+	for key, raw := range members {
+		field, ok := fields[key]
+		if !ok {
+			if p.Extensions == nil {
+				p.Extensions = make(map[string]interface{})
+			}
+			var value interface{}
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return failure.Annotate(err, "problem member '%s' is invalid", key)
+			}
+			p.Extensions[key] = value
+			continue
+		}
+		if err := json.Unmarshal(raw, field); err != nil {
+			return failure.Annotate(err, "problem member '%s' is invalid", key)
+		}
+	}
+	return nil
+}
+
+// MarshalXML implements xml.Marshaler. Lists are written with
+// elements named i, objects with elements named like their keys.
+func (p *Problem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = xml.StartElement{
+		Name: xml.Name{Space: problemNamespace, Local: "problem"},
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	members := p.members()
+	for _, key := range []string{"type", "title", "status", "detail", "instance"} {
+		if value, ok := members[key]; ok {
+			if err := encodeXMLValue(e, key, value); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range sortedKeys(p.Extensions) {
+		if _, ok := members[key]; ok {
+			continue
+		}
+		if err := encodeXMLValue(e, key, p.Extensions[key]); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// members returns the set standard members.
+func (p *Problem) members() map[string]interface{} {
+	members := make(map[string]interface{}, 5)
+	if p.Type != "" {
+		members["type"] = p.Type
+	}
+	if p.Title != "" {
+		members["title"] = p.Title
+	}
+	if p.Status != 0 {
+		members["status"] = p.Status
+	}
+	if p.Detail != "" {
+		members["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		members["instance"] = p.Instance
+	}
+	return members
+}
+
+//--------------------
+// PROBLEM TOOLS
+//--------------------
+
+// WriteProblem writes the problem as response to the request. It is
+// encoded as XML if the request accepts XML but not JSON or if it
+// contains XML, otherwise as JSON. A missing status is set to 500.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) error {
+	if p.Status == 0 {
+		p.Status = http.StatusInternalServerError
+	}
+	var data []byte
+	var err error
+	if isProblemXMLRequested(r.Header) {
+		data, err = xml.Marshal(p)
+		if err != nil {
+			return failure.Annotate(err, "cannot marshal problem to XML")
+		}
+		data = append([]byte(xml.Header), data...)
+		w.Header().Set(HeaderContentType, ContentTypeProblemXML)
+	} else {
+		data, err = json.Marshal(p)
+		if err != nil {
+			return failure.Annotate(err, "cannot marshal problem to JSON")
+		}
+		w.Header().Set(HeaderContentType, ContentTypeProblemJSON)
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	return WriteBody(w, data)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// isProblemXMLRequested checks if the problem has to be encoded as XML.
+func isProblemXMLRequested(h http.Header) bool {
+	if AcceptsContentType(h, ContentTypeJSON) || AcceptsContentType(h, ContentTypeProblemJSON) {
+		return false
+	}
+	return AcceptsContentType(h, ContentTypeXML) ||
+		AcceptsContentType(h, ContentTypeProblemXML) ||
+		ContainsContentType(h, ContentTypeXML)
+}
+
+// encodeXMLValue encodes the value as element with the given name.
+func encodeXMLValue(e *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{
+		Name: xml.Name{Local: name},
+	}
+	switch tv := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return encodeXMLChildren(e, start, len(tv), func(i int) (string, interface{}) {
+			return "i", tv[i]
+		})
+	case []string:
+		return encodeXMLChildren(e, start, len(tv), func(i int) (string, interface{}) {
+			return "i", tv[i]
+		})
+	case map[string]interface{}:
+		keys := sortedKeys(tv)
+		return encodeXMLChildren(e, start, len(keys), func(i int) (string, interface{}) {
+			return keys[i], tv[keys[i]]
+		})
+	default:
+		return e.EncodeElement(fmt.Sprint(value), start)
+	}
+}
+
+// encodeXMLChildren encodes an element with n child elements.
+func encodeXMLChildren(e *xml.Encoder, start xml.StartElement, n int, child func(i int) (string, interface{})) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		name, value := child(i)
+		if err := encodeXMLValue(e, name, value); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EOF
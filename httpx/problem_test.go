@@ -0,0 +1,143 @@
+// Tideland Go Network - HTTP Extensions - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package httpx_test // import "tideland.dev/go/net/httpx_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/httpx"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestProblemJSON tests the marshalling and unmarshalling of problems
+// as JSON.
+func TestProblemJSON(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	p := newCreditProblem()
+
+	assert.Equal(p.Error(), "403 Forbidden: Your current balance is 30, but that costs 50.")
+
+	data, err := json.Marshal(p)
+	assert.NoError(err)
+	assert.Equal(string(data), `{"accounts":["/account/12345","/account/67890"],`+
+		`"balance":30,"detail":"Your current balance is 30, but that costs 50.",`+
+		`"instance":"/account/12345/msgs/abc","status":403,"title":"Forbidden",`+
+		`"type":"https://example.com/probs/out-of-credit"}`)
+
+	var up httpx.Problem
+	err = json.Unmarshal(data, &up)
+	assert.NoError(err)
+	assert.Equal(up.Type, p.Type)
+	assert.Equal(up.Title, p.Title)
+	assert.Equal(up.Status, p.Status)
+	assert.Equal(up.Detail, p.Detail)
+	assert.Equal(up.Instance, p.Instance)
+	assert.Equal(up.Extensions, map[string]interface{}{
+		"balance":  30.0,
+		"accounts": []interface{}{"/account/12345", "/account/67890"},
+	})
+
+	// Extensions cannot replace standard members.
+	p = httpx.NewProblem(http.StatusNotFound, "")
+	p.Extensions = map[string]interface{}{"status": 200, "title": "OK"}
+	data, err = json.Marshal(p)
+	assert.NoError(err)
+	assert.Equal(string(data), `{"status":404,"title":"Not Found"}`)
+	assert.Equal(p.Error(), "404 Not Found")
+
+	err = json.Unmarshal([]byte(`{"status":"404"}`), &up)
+	assert.ErrorMatch(err, ".*problem member 'status' is invalid.*")
+}
+
+// TestProblemXML tests the marshalling of problems as XML.
+func TestProblemXML(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	p := newCreditProblem()
+	p.Extensions["limits"] = map[string]interface{}{"daily": 100, "monthly": nil}
+
+	data, err := xml.Marshal(p)
+	assert.NoError(err)
+	assert.Equal(string(data), `<problem xmlns="urn:ietf:rfc:7807">`+
+		`<type>https://example.com/probs/out-of-credit</type>`+
+		`<title>Forbidden</title>`+
+		`<status>403</status>`+
+		`<detail>Your current balance is 30, but that costs 50.</detail>`+
+		`<instance>/account/12345/msgs/abc</instance>`+
+		`<accounts><i>/account/12345</i><i>/account/67890</i></accounts>`+
+		`<balance>30</balance>`+
+		`<limits><daily>100</daily></limits>`+
+		`</problem>`)
+}
+
+// TestWriteProblem tests the writing of problems depending on
+// the request.
+func TestWriteProblem(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tests := []struct {
+		accept      string
+		contentType string
+		expected    string
+	}{
+		{"", "", httpx.ContentTypeProblemJSON},
+		{"application/json", "", httpx.ContentTypeProblemJSON},
+		{"application/problem+json, application/problem+xml", "", httpx.ContentTypeProblemJSON},
+		{"application/problem+xml", "", httpx.ContentTypeProblemXML},
+		{"text/html, application/xml;q=0.9", "", httpx.ContentTypeProblemXML},
+		{"", "application/xml", httpx.ContentTypeProblemXML},
+		{"application/json", "application/xml", httpx.ContentTypeProblemJSON},
+	}
+	for _, test := range tests {
+		assert.Logf("testing accept %q and content type %q", test.accept, test.contentType)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(httpx.HeaderAccept, test.accept)
+		r.Header.Set(httpx.HeaderContentType, test.contentType)
+		w := httptest.NewRecorder()
+		err := httpx.WriteProblem(w, r, httpx.NewProblem(http.StatusConflict, "conflict"))
+		assert.NoError(err)
+		assert.Equal(w.Code, http.StatusConflict)
+		assert.Equal(w.Header().Get(httpx.HeaderContentType), test.expected)
+		assert.Substring("conflict", w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	err := httpx.WriteProblem(w, r, &httpx.Problem{})
+	assert.NoError(err)
+	assert.Equal(w.Code, http.StatusInternalServerError)
+	assert.Equal(w.Body.String(), `{"status":500}`)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// newCreditProblem creates the problem of the examples of RFC 7807.
+func newCreditProblem() *httpx.Problem {
+	p := httpx.NewProblem(http.StatusForbidden, "Your current balance is 30, but that costs 50.")
+	p.Type = "https://example.com/probs/out-of-credit"
+	p.Instance = "/account/12345/msgs/abc"
+	p.Extensions = map[string]interface{}{
+		"balance":  30,
+		"accounts": []string{"/account/12345", "/account/67890"},
+	}
+	return p
+}
+
+// EOF
@@ -0,0 +1,196 @@
+// Tideland Go Network - JSON Web Token - Discovery
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package discovery // import "tideland.dev/go/net/jwt/discovery"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Well-known paths of the metadata documents.
+const (
+	OpenIDConfigurationPath = "/.well-known/openid-configuration"
+	OAuthMetadataPath       = "/.well-known/oauth-authorization-server"
+)
+
+//--------------------
+// DOCUMENT
+//--------------------
+
+// Document contains the metadata of an identity provider. It
+// covers the fields of OpenID Connect discovery and OAuth 2.0
+// authorization server metadata needed for token handling.
+type Document struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                     string   `json:"token_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	SubjectTypesSupported             []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	ClaimsSupported                   []string `json:"claims_supported,omitempty"`
+}
+
+// Fetch retrieves the metadata of the issuer. It first tries the
+// OpenID Connect discovery document and then the OAuth 2.0
+// authorization server metadata. A nil client is replaced by
+// the default HTTP client.
+func Fetch(ctx context.Context, client *http.Client, issuer string) (*Document, error) {
+	doc, err := FetchOpenID(ctx, client, issuer)
+	if err == nil {
+		return doc, nil
+	}
+	doc, oerr := FetchOAuth(ctx, client, issuer)
+	if oerr != nil {
+		return nil, failure.Collect(err, oerr)
+	}
+	return doc, nil
+}
+
+// FetchOpenID retrieves the OpenID Connect discovery document of
+// the issuer. It is located at the issuer URL with the appended
+// path /.well-known/openid-configuration.
+func FetchOpenID(ctx context.Context, client *http.Client, issuer string) (*Document, error) {
+	location := strings.TrimSuffix(issuer, "/") + OpenIDConfigurationPath
+	return fetch(ctx, client, issuer, location)
+}
+
+// FetchOAuth retrieves the OAuth 2.0 authorization server metadata
+// of the issuer. The well-known path is inserted between host and
+// path of the issuer URL as defined by RFC 8414.
+func FetchOAuth(ctx context.Context, client *http.Client, issuer string) (*Document, error) {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return nil, failure.Annotate(err, "invalid issuer URL")
+	}
+	u.Path = OAuthMetadataPath + strings.TrimSuffix(u.Path, "/")
+	return fetch(ctx, client, issuer, u.String())
+}
+
+// Algorithms returns the supported asymmetric signing algorithms of
+// the ID tokens known by the token package. The HMAC algorithms and
+// "none" are never contained, public keys of a provider cannot be
+// used for them. If the document names no algorithm RS256 is
+// returned as default defined by OpenID Connect.
+func (d *Document) Algorithms() []token.Algorithm {
+	if len(d.IDTokenSigningAlgValuesSupported) == 0 {
+		return []token.Algorithm{token.RS256}
+	}
+	var algorithms []token.Algorithm
+	for _, alg := range d.IDTokenSigningAlgValuesSupported {
+		algorithm := token.Algorithm(alg)
+		switch algorithm {
+		case token.ES256, token.ES384, token.ES512,
+			token.EdDSA,
+			token.PS256, token.PS384, token.PS512,
+			token.RS256, token.RS384, token.RS512:
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+// KeySet returns the remote key set referenced by the document.
+func (d *Document) KeySet(client *http.Client) (*KeySet, error) {
+	if d.JWKSURI == "" {
+		return nil, failure.New("document contains no JWKS URI")
+	}
+	return NewKeySet(client, d.JWKSURI), nil
+}
+
+// JWTHandlerConfig creates a configuration for the web.JWTHandler
+// verifying tokens with the keys of the key set, the issuer, and the
+// supported algorithms of the document. If the document names no
+// usable algorithm an error is returned, as an empty list would
+// allow all algorithms.
+func (d *Document) JWTHandlerConfig(client *http.Client) (*web.JWTHandlerConfig, error) {
+	ks, err := d.KeySet(client)
+	if err != nil {
+		return nil, err
+	}
+	algorithms := d.Algorithms()
+	if len(algorithms) == 0 {
+		return nil, failure.New("document names no supported asymmetric algorithm")
+	}
+	return &web.JWTHandlerConfig{
+		KeyFunc:    ks.KeyFunc(),
+		Issuer:     d.Issuer,
+		Algorithms: algorithms,
+	}, nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// fetch retrieves and validates a metadata document.
+func fetch(ctx context.Context, client *http.Client, issuer, location string) (*Document, error) {
+	data, err := get(ctx, client, location)
+	if err != nil {
+		return nil, err
+	}
+	var doc Document
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal metadata document")
+	}
+	if doc.Issuer != issuer {
+		return nil, failure.New("issuer %q of metadata document does not match %q", doc.Issuer, issuer)
+	}
+	return &doc, nil
+}
+
+// get performs a GET request and returns the body in case of
+// status OK.
+func get(ctx context.Context, client *http.Client, location string) ([]byte, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot create request for %q", location)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(httpx.HeaderAccept, httpx.ContentTypeJSON)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot retrieve %q", location)
+	}
+	data, err := httpx.ReadBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, failure.New("retrieving %q returned status %d", location, resp.StatusCode)
+	}
+	return data, nil
+}
+
+// EOF
@@ -0,0 +1,266 @@
+// Tideland Go Network - JSON Web Token - Discovery - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package discovery_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/discovery"
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/net/web"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestFetchOpenID tests the retrieval of an OpenID Connect
+// discovery document.
+func TestFetchOpenID(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx := context.Background()
+	idp := startIdentityProvider(assert, nil)
+	defer idp.Close()
+
+	doc, err := discovery.FetchOpenID(ctx, nil, idp.URL)
+	assert.NoError(err)
+	assert.Equal(doc.Issuer, idp.URL)
+	assert.Equal(doc.JWKSURI, idp.URL+"/keys")
+	assert.Equal(doc.Algorithms(), []token.Algorithm{token.RS256, token.ES256, token.EdDSA})
+
+	// OAuth metadata for an issuer with path.
+	doc, err = discovery.Fetch(ctx, nil, idp.URL+"/tenant")
+	assert.NoError(err)
+	assert.Equal(doc.Issuer, idp.URL+"/tenant")
+	assert.Equal(doc.Algorithms(), []token.Algorithm{token.RS256})
+
+	// Not matching issuer.
+	_, err = discovery.FetchOpenID(ctx, nil, idp.URL+"/wrong")
+	assert.ErrorMatch(err, ".*does not match.*")
+	_, err = discovery.Fetch(ctx, nil, idp.URL+"/unknown")
+	assert.ErrorMatch(err, ".*returned status 404.*")
+}
+
+// TestJWTHandlerConfig tests the verification of tokens by a
+// JWTHandler configured by a discovery document.
+func TestJWTHandlerConfig(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	idp := startIdentityProvider(assert, &key.PublicKey)
+	defer idp.Close()
+
+	doc, err := discovery.Fetch(ctx, nil, idp.URL)
+	assert.NoError(err)
+	config, err := doc.JWTHandlerConfig(nil)
+	assert.NoError(err)
+	assert.Equal(config.Issuer, idp.URL)
+	assert.Equal(config.Algorithms, []token.Algorithm{token.RS256, token.ES256, token.EdDSA})
+
+	// No asymmetric algorithm left.
+	doc, err = discovery.FetchOpenID(ctx, nil, idp.URL+"/symmetric")
+	assert.NoError(err)
+	assert.Length(doc.Algorithms(), 0)
+	_, err = doc.JWTHandlerConfig(nil)
+	assert.ErrorMatch(err, ".*no supported asymmetric algorithm.*")
+
+	handler := web.NewJWTHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), config)
+
+	tests := []struct {
+		issuer     string
+		key        token.Key
+		algorithm  token.Algorithm
+		statusCode int
+	}{
+		{idp.URL, key, token.RS256, http.StatusNoContent},
+		{"https://other.example.com", key, token.RS256, http.StatusUnauthorized},
+		{idp.URL, key, token.PS256, http.StatusUnauthorized},
+		{idp.URL, []byte("secret"), token.HS256, http.StatusUnauthorized},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s / %s", i, test.issuer, test.algorithm)
+		claims := token.NewClaims()
+		claims.SetIssuer(test.issuer)
+		jwt, err := token.Encode(claims, test.key, test.algorithm)
+		assert.NoError(err)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", "Bearer "+jwt.String())
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(w.Code, test.statusCode)
+	}
+}
+
+// TestKeySetRefresh tests that failing or hanging loads of the key
+// set do not affect the verification with the known keys.
+func TestKeySetRefresh(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	jwk, err := token.NewJWK(key)
+	assert.NoError(err)
+	jwk.KeyID = "one"
+
+	var mu sync.Mutex
+	mode := "fail"
+	loads := 0
+	hangingc := make(chan struct{})
+	releasec := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current := mode
+		loads++
+		mu.Unlock()
+		switch current {
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case "hang":
+			close(hangingc)
+			<-releasec
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(json.NewEncoder(w).Encode(token.JWKSet{Keys: []*token.JWK{jwk}}))
+	}))
+	defer srv.Close()
+	setMode := func(m string) {
+		mu.Lock()
+		defer mu.Unlock()
+		mode = m
+	}
+	countLoads := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return loads
+	}
+
+	ks := discovery.NewKeySet(nil, srv.URL)
+	jwt, err := token.EncodeWithHeader(token.NewClaims(), key, token.Header{
+		Algorithm: token.ES256,
+		KeyID:     "one",
+	})
+	assert.NoError(err)
+	verify := func() {
+		_, err := token.VerifyWithKeyFunc(jwt.String(), ks.KeyFunc())
+		assert.NoError(err)
+	}
+
+	// A failed initial load is not repeated immediately.
+	for i := 0; i < 3; i++ {
+		_, err = token.VerifyWithKeyFunc(jwt.String(), ks.KeyFunc())
+		assert.ErrorMatch(err, ".*returned status 500.*")
+	}
+	assert.Equal(countLoads(), 1)
+	setMode("ok")
+	assert.NoError(ks.Refresh())
+	verify()
+
+	// A failing refresh keeps the previous keys.
+	setMode("fail")
+	assert.ErrorMatch(ks.Refresh(), ".*returned status 500.*")
+	verify()
+
+	// A hanging refresh does not block the verification.
+	setMode("hang")
+	refreshc := make(chan error, 1)
+	go func() {
+		refreshc <- ks.Refresh()
+	}()
+	<-hangingc
+	verifiedc := make(chan struct{})
+	go func() {
+		verify()
+		close(verifiedc)
+	}()
+	select {
+	case <-verifiedc:
+	case <-time.After(time.Second):
+		assert.Fail("verification blocked by refresh")
+	}
+	close(releasec)
+	assert.NoError(<-refreshc)
+	verify()
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// startIdentityProvider starts a local identity provider serving
+// the metadata and the key set.
+func startIdentityProvider(assert *asserts.Asserts, key *rsa.PublicKey) *httptest.Server {
+	var srv *httptest.Server
+	reply := func(w http.ResponseWriter, v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(json.NewEncoder(w).Encode(v))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{
+			"issuer":                                srv.URL,
+			"jwks_uri":                              srv.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256", "none", "HS256", "ES256", "EdDSA", "HS512"},
+		})
+	})
+	mux.HandleFunc("/symmetric/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{
+			"issuer":                                srv.URL + "/symmetric",
+			"jwks_uri":                              srv.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"HS256", "none", "XY999"},
+		})
+	})
+	mux.HandleFunc("/wrong/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{
+			"issuer": srv.URL,
+		})
+	})
+	mux.HandleFunc("/.well-known/oauth-authorization-server/tenant", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, map[string]interface{}{
+			"issuer":   srv.URL + "/tenant",
+			"jwks_uri": srv.URL + "/tenant/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		keys := []map[string]string{}
+		if key != nil {
+			keys = append(keys, map[string]string{
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		reply(w, map[string]interface{}{
+			"keys": keys,
+		})
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+// EOF
@@ -0,0 +1,15 @@
+// Tideland Go Network - JSON Web Token - Discovery
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+// Package discovery fetches and validates the metadata of identity
+// providers, namely the OpenID Connect discovery document and the
+// OAuth 2.0 authorization server metadata (RFC 8414). The metadata
+// and the referenced JSON Web Key Set are used to configure the
+// verification of tokens by the web.JWTHandler.
+package discovery // import "tideland.dev/go/net/jwt/discovery"
+
+// EOF
@@ -0,0 +1,167 @@
+// Tideland Go Network - JSON Web Token - Discovery
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package discovery // import "tideland.dev/go/net/jwt/discovery"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// KEY SET
+//--------------------
+
+const (
+	// minRefreshInterval limits how often a key set is reloaded
+	// due to unknown key IDs or after a failed initial load.
+	minRefreshInterval = 10 * time.Second
+
+	// loadTimeout limits the duration of loading a key set.
+	loadTimeout = 10 * time.Second
+)
+
+// keySetLoad is a running load of a key set other callers
+// can wait for.
+type keySetLoad struct {
+	done chan struct{}
+	err  error
+}
+
+// KeySet provides the keys of a remote JSON Web Key Set. It is loaded
+// lazily and reloaded when a token references an unknown key ID, so
+// that key rotations of the identity provider are followed. Only one
+// load runs at a time, it is done without blocking the verifications
+// with already known keys. If a reload fails the previous keys are
+// used further. If the initial load fails the error is returned
+// until the next try is allowed after ten seconds.
+type KeySet struct {
+	mu        sync.Mutex
+	client    *http.Client
+	location  string
+	set       *token.JWKSet
+	err       error
+	refreshed time.Time
+	load      *keySetLoad
+}
+
+// NewKeySet creates a key set loading the keys from the given
+// location. A nil client is replaced by the default HTTP client.
+// Each load is limited to ten seconds.
+func NewKeySet(client *http.Client, location string) *KeySet {
+	return &KeySet{
+		client:   client,
+		location: location,
+	}
+}
+
+// KeyFor returns the key for the verification of the passed token.
+func (ks *KeySet) KeyFor(jwt *token.JWT) (token.Key, error) {
+	set, err := ks.keys(jwt.KeyID())
+	if err != nil {
+		return nil, err
+	}
+	return set.KeyFor(jwt)
+}
+
+// KeyFunc returns the key set as key function for the verification.
+func (ks *KeySet) KeyFunc() token.KeyFunc {
+	return ks.KeyFor
+}
+
+// Refresh reloads the keys from the remote location. If a load is
+// already running it waits for its result.
+func (ks *KeySet) Refresh() error {
+	ks.mu.Lock()
+	if load := ks.load; load != nil {
+		ks.mu.Unlock()
+		<-load.done
+		return load.err
+	}
+	load := &keySetLoad{
+		done: make(chan struct{}),
+	}
+	ks.load = load
+	ks.mu.Unlock()
+
+	set, err := ks.fetch()
+
+	ks.mu.Lock()
+	if err == nil {
+		ks.set = set
+	}
+	ks.err = err
+	ks.refreshed = time.Now()
+	ks.load = nil
+	ks.mu.Unlock()
+	load.err = err
+	close(load.done)
+	return err
+}
+
+// keys returns the current key set. It is loaded if not yet done
+// or if the key ID is unknown and the last load is old enough.
+func (ks *KeySet) keys(kid string) (*token.JWKSet, error) {
+	ks.mu.Lock()
+	set := ks.set
+	retry := time.Since(ks.refreshed) > minRefreshInterval
+	stale := set == nil
+	switch {
+	case stale && !retry && ks.err != nil:
+		// Initial load failed recently.
+		err := ks.err
+		ks.mu.Unlock()
+		return nil, err
+	case !stale && kid != "":
+		if _, ok := set.Lookup(kid); !ok && retry {
+			// Unknown key, maybe it has been rotated.
+			stale = true
+		}
+	}
+	ks.mu.Unlock()
+	if !stale {
+		return set, nil
+	}
+	if err := ks.Refresh(); err != nil {
+		if set == nil {
+			return nil, err
+		}
+		logger.Warningf("using previous keys: %v", err)
+		return set, nil
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.set, nil
+}
+
+// fetch loads the keys from the remote location.
+func (ks *KeySet) fetch() (*token.JWKSet, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	data, err := get(ctx, ks.client, ks.location)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot load key set")
+	}
+	set, err := token.ReadJWKSet(bytes.NewReader(data))
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot load key set")
+	}
+	return set, nil
+}
+
+// EOF
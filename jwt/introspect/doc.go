@@ -0,0 +1,14 @@
+// Tideland Go Network - JSON Web Token - Introspection
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+// Package introspect provides a client for the OAuth 2.0 token
+// introspection (RFC 7662). It allows to check opaque reference
+// tokens against the introspection endpoint of an authorization
+// server and caches the results.
+package introspect // import "tideland.dev/go/net/jwt/introspect"
+
+// EOF
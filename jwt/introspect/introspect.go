@@ -0,0 +1,276 @@
+// Tideland Go Network - JSON Web Token - Introspection
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package introspect // import "tideland.dev/go/net/jwt/introspect"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// CONFIGURATION
+//--------------------
+
+// Config contains the configuration of an Introspector. Only
+// the endpoint is mandatory. Without HTTP client the default
+// client is used, the TTL of cached results defaults to one
+// minute, and the cleanup interval to the TTL. MaxEntries is
+// the maximum number of cached results, default is 10,000. If
+// they grow beyond it the TTL is temporarily reduced for cleanup.
+type Config struct {
+	Endpoint     string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+	TTL          time.Duration
+	Interval     time.Duration
+	MaxEntries   int
+}
+
+//--------------------
+// CACHE ENTRY
+//--------------------
+
+// cacheEntry manages the claims of an active token and
+// the time until it may be used.
+type cacheEntry struct {
+	claims token.Claims
+	until  time.Time
+}
+
+//--------------------
+// INTROSPECTOR
+//--------------------
+
+const (
+	// defaultTimeout is the default timeout for synchronous actions.
+	defaultTimeout = 5 * time.Second
+
+	// defaultMaxEntries is the default maximum number of cached
+	// results.
+	defaultMaxEntries = 10000
+)
+
+// Introspector checks tokens against the introspection endpoint
+// of an authorization server. The claims of active tokens are
+// cached for the configured TTL but never beyond their expiration.
+type Introspector struct {
+	ctx          context.Context
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+	entries      map[string]*cacheEntry
+	ttl          time.Duration
+	interval     time.Duration
+	maxEntries   int
+	actionc      chan func()
+}
+
+// New creates a new introspector. The background cleanup
+// of the cache stops when the context is done.
+func New(ctx context.Context, config *Config) *Introspector {
+	if config == nil || config.Endpoint == "" {
+		panic("need introspection endpoint")
+	}
+	i := &Introspector{
+		ctx:          ctx,
+		endpoint:     config.Endpoint,
+		clientID:     config.ClientID,
+		clientSecret: config.ClientSecret,
+		client:       http.DefaultClient,
+		entries:      map[string]*cacheEntry{},
+		ttl:          time.Minute,
+		maxEntries:   defaultMaxEntries,
+		actionc:      make(chan func(), 1),
+	}
+	if config.Client != nil {
+		i.client = config.Client
+	}
+	if config.TTL != 0 {
+		i.ttl = config.TTL
+	}
+	i.interval = i.ttl
+	if config.Interval != 0 {
+		i.interval = config.Interval
+	}
+	if config.MaxEntries > 0 {
+		i.maxEntries = config.MaxEntries
+	}
+	go i.backend()
+	return i
+}
+
+// Introspect returns the claims of the passed token if the
+// authorization server reports it as active. Otherwise an
+// error is returned.
+func (i *Introspector) Introspect(ctx context.Context, st string) (token.Claims, error) {
+	claims, err := i.get(st)
+	if err != nil {
+		return nil, err
+	}
+	if claims != nil {
+		return claims, nil
+	}
+	claims, err = i.request(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	if err = i.put(st, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// VerifyToken implements web.TokenVerifier. So the introspector
+// can be used by the JWTHandler.
+func (i *Introspector) VerifyToken(ctx context.Context, st string) (token.Claims, error) {
+	return i.Introspect(ctx, st)
+}
+
+// Cleanup manually tells the introspector to cleanup the cache.
+func (i *Introspector) Cleanup() error {
+	return i.doSync(func() {
+		i.cleanup(i.ttl)
+	}, defaultTimeout)
+}
+
+// request posts the token to the introspection endpoint and
+// maps the response into claims.
+func (i *Introspector) request(ctx context.Context, st string) (token.Claims, error) {
+	form := url.Values{}
+	form.Set("token", st)
+	form.Set("token_type_hint", "access_token")
+	req, err := http.NewRequest(http.MethodPost, i.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot create introspection request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(httpx.HeaderContentType, httpx.ContentTypeURLEncoded)
+	req.Header.Set(httpx.HeaderAccept, httpx.ContentTypeJSON)
+	if i.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(i.clientID), url.QueryEscape(i.clientSecret))
+	}
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot perform introspection request")
+	}
+	data, err := httpx.ReadBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, failure.New("introspection endpoint returned status %d", resp.StatusCode)
+	}
+	var claims token.Claims
+	if err = json.Unmarshal(data, &claims); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal introspection response")
+	}
+	active, ok := claims.GetBool("active")
+	if !ok || !active {
+		return nil, failure.New("token is not active")
+	}
+	return claims, nil
+}
+
+// get retrieves the claims of a token from the cache. They are
+// nil if not found.
+func (i *Introspector) get(st string) (token.Claims, error) {
+	var claims token.Claims
+	err := i.doSync(func() {
+		entry, ok := i.entries[st]
+		if !ok {
+			return
+		}
+		if time.Now().After(entry.until) {
+			delete(i.entries, st)
+			return
+		}
+		claims = entry.claims
+	}, defaultTimeout)
+	return claims, err
+}
+
+// put adds the claims of a token to the cache.
+func (i *Introspector) put(st string, claims token.Claims) error {
+	until := time.Now().Add(i.ttl)
+	if exp, ok := claims.Expiration(); ok && exp.Before(until) {
+		until = exp
+	}
+	return i.doSync(func() {
+		i.entries[st] = &cacheEntry{claims, until}
+		lenEntries := len(i.entries)
+		if lenEntries > i.maxEntries {
+			ttl := int64(i.ttl) / int64(lenEntries) * int64(i.maxEntries)
+			i.cleanup(time.Duration(ttl))
+		}
+	}, defaultTimeout)
+}
+
+// cleanup removes the outdated entries and those cached longer
+// than the passed TTL.
+func (i *Introspector) cleanup(ttl time.Duration) {
+	now := time.Now()
+	horizon := now.Add(i.ttl - ttl)
+	for st, entry := range i.entries {
+		if now.After(entry.until) || entry.until.Before(horizon) {
+			delete(i.entries, st)
+		}
+	}
+}
+
+// doSync performs a function in the backend synchronously.
+func (i *Introspector) doSync(action func(), timeout time.Duration) error {
+	donec := make(chan struct{})
+	i.actionc <- func() {
+		action()
+		close(donec)
+	}
+	select {
+	case <-donec:
+		return nil
+	case <-time.After(timeout):
+		return failure.New("introspection cache action timeout")
+	}
+}
+
+// backend is the goroutine of the introspector cache.
+func (i *Introspector) backend() {
+	ticker := time.NewTicker(i.interval)
+	for {
+		select {
+		case <-i.ctx.Done():
+			i.entries = map[string]*cacheEntry{}
+			ticker.Stop()
+			return
+		case action := <-i.actionc:
+			action()
+		case <-ticker.C:
+			go func() {
+				if err := i.Cleanup(); err != nil {
+					logger.Errorf("JWT introspection: %v", err)
+				}
+			}()
+		}
+	}
+}
+
+// EOF
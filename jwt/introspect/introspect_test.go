@@ -0,0 +1,177 @@
+// Tideland Go Network - JSON Web Token - Introspection - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package introspect_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/introspect"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestIntrospect tests the introspection of active and
+// inactive tokens.
+func TestIntrospect(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing introspection")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int32
+	srv := startIntrospectionServer(assert, &calls)
+	defer srv.Close()
+	i := introspect.New(ctx, &introspect.Config{
+		Endpoint:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+	})
+
+	claims, err := i.Introspect(ctx, "active-token")
+	assert.NoError(err)
+	sub, ok := claims.Subject()
+	assert.True(ok)
+	assert.Equal(sub, "john")
+	scope, ok := claims.GetString("scope")
+	assert.True(ok)
+	assert.Equal(scope, "read write")
+
+	claims, err = i.Introspect(ctx, "inactive-token")
+	assert.ErrorMatch(err, ".*token is not active.*")
+	assert.Nil(claims)
+
+	i = introspect.New(ctx, &introspect.Config{
+		Endpoint:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "wrong",
+	})
+	claims, err = i.Introspect(ctx, "active-token")
+	assert.ErrorMatch(err, ".*returned status 401.*")
+	assert.Nil(claims)
+}
+
+// TestIntrospectCache tests the caching of introspection results.
+func TestIntrospectCache(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing introspection caching")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int32
+	srv := startIntrospectionServer(assert, &calls)
+	defer srv.Close()
+	i := introspect.New(ctx, &introspect.Config{
+		Endpoint:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TTL:          200 * time.Millisecond,
+		Interval:     50 * time.Millisecond,
+	})
+
+	for n := 0; n < 5; n++ {
+		_, err := i.VerifyToken(ctx, "active-token")
+		assert.NoError(err)
+	}
+	assert.Equal(atomic.LoadInt32(&calls), int32(1))
+	// Wait for cleanup.
+	time.Sleep(300 * time.Millisecond)
+	_, err := i.VerifyToken(ctx, "active-token")
+	assert.NoError(err)
+	assert.Equal(atomic.LoadInt32(&calls), int32(2))
+	// Inactive tokens are not cached.
+	for n := 0; n < 3; n++ {
+		_, err := i.VerifyToken(ctx, "inactive-token")
+		assert.ErrorMatch(err, ".*token is not active.*")
+	}
+	assert.Equal(atomic.LoadInt32(&calls), int32(5))
+}
+
+// TestIntrospectCacheMaxEntries tests the early cleanup of the
+// cache when it grows beyond its maximum.
+func TestIntrospectCacheMaxEntries(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing introspection cache limit")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int32
+	srv := startIntrospectionServer(assert, &calls)
+	defer srv.Close()
+	i := introspect.New(ctx, &introspect.Config{
+		Endpoint:     srv.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		TTL:          time.Second,
+		Interval:     time.Hour,
+		MaxEntries:   2,
+	})
+	verify := func(st string, expected int32) {
+		_, err := i.VerifyToken(ctx, st)
+		assert.NoError(err)
+		assert.Equal(atomic.LoadInt32(&calls), expected)
+	}
+
+	verify("active-1", 1)
+	verify("active-2", 2)
+	verify("active-1", 2)
+	time.Sleep(800 * time.Millisecond)
+	// Exceeding the maximum removes the older entries.
+	verify("active-3", 3)
+	verify("active-3", 3)
+	verify("active-1", 4)
+	verify("active-2", 5)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// startIntrospectionServer starts a local stand-in for an
+// introspection endpoint and counts the calls.
+func startIntrospectionServer(assert *asserts.Asserts, calls *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		assert.Equal(r.Method, http.MethodPost)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var response map[string]interface{}
+		switch {
+		case strings.HasPrefix(r.FormValue("token"), "active-"):
+			response = map[string]interface{}{
+				"active":    true,
+				"sub":       "john",
+				"scope":     "read write",
+				"client_id": "client",
+				"exp":       time.Now().Add(time.Hour).Unix(),
+			}
+		default:
+			response = map[string]interface{}{
+				"active": false,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(response)
+		assert.NoError(err)
+	}))
+}
+
+// EOF
@@ -0,0 +1,13 @@
+// Tideland Go Network - JSON Web Token - OpenID Connect
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+// Package oidc provides the validation of OpenID Connect ID tokens
+// following the steps of OpenID Connect Core 1.0 section 3.1.3.7.
+// Valid ID tokens give typed access to the standard claims.
+package oidc // import "tideland.dev/go/net/jwt/oidc"
+
+// EOF
@@ -0,0 +1,130 @@
+// Tideland Go Network - JSON Web Token - OpenID Connect
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package oidc // import "tideland.dev/go/net/jwt/oidc"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"time"
+
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// ID TOKEN
+//--------------------
+
+// IDToken is a validated OpenID Connect ID token. It provides
+// typed access to the claims defined by OpenID Connect.
+type IDToken struct {
+	jwt *token.JWT
+}
+
+// JWT returns the underlying verified token.
+func (t *IDToken) JWT() *token.JWT {
+	return t.jwt
+}
+
+// Claims returns all claims of the ID token.
+func (t *IDToken) Claims() token.Claims {
+	return t.jwt.Claims()
+}
+
+// Issuer returns the "iss" claim.
+func (t *IDToken) Issuer() string {
+	iss, _ := t.Claims().Issuer()
+	return iss
+}
+
+// Subject returns the "sub" claim.
+func (t *IDToken) Subject() string {
+	sub, _ := t.Claims().Subject()
+	return sub
+}
+
+// Audience returns the "aud" claim.
+func (t *IDToken) Audience() []string {
+	aud, _ := t.Claims().Audience()
+	return aud
+}
+
+// Expiration returns the "exp" claim.
+func (t *IDToken) Expiration() time.Time {
+	exp, _ := t.Claims().Expiration()
+	return exp
+}
+
+// IssuedAt returns the "iat" claim.
+func (t *IDToken) IssuedAt() time.Time {
+	iat, _ := t.Claims().IssuedAt()
+	return iat
+}
+
+// Nonce returns the "nonce" claim.
+func (t *IDToken) Nonce() (string, bool) {
+	return t.Claims().GetString("nonce")
+}
+
+// AuthorizedParty returns the "azp" claim.
+func (t *IDToken) AuthorizedParty() (string, bool) {
+	return t.Claims().GetString("azp")
+}
+
+// AuthTime returns the "auth_time" claim.
+func (t *IDToken) AuthTime() (time.Time, bool) {
+	return t.Claims().GetTime("auth_time")
+}
+
+// AuthContextClass returns the "acr" claim.
+func (t *IDToken) AuthContextClass() (string, bool) {
+	return t.Claims().GetString("acr")
+}
+
+// AuthMethods returns the "amr" claim.
+func (t *IDToken) AuthMethods() ([]string, bool) {
+	var amr []string
+	ok, err := t.Claims().GetMarshalled("amr", &amr)
+	if !ok || err != nil {
+		return nil, false
+	}
+	return amr, true
+}
+
+// AccessTokenHash returns the "at_hash" claim.
+func (t *IDToken) AccessTokenHash() (string, bool) {
+	return t.Claims().GetString("at_hash")
+}
+
+// CodeHash returns the "c_hash" claim.
+func (t *IDToken) CodeHash() (string, bool) {
+	return t.Claims().GetString("c_hash")
+}
+
+// Email returns the "email" claim.
+func (t *IDToken) Email() (string, bool) {
+	return t.Claims().GetString("email")
+}
+
+// EmailVerified returns the "email_verified" claim.
+func (t *IDToken) EmailVerified() (bool, bool) {
+	return t.Claims().GetBool("email_verified")
+}
+
+// Name returns the "name" claim.
+func (t *IDToken) Name() (string, bool) {
+	return t.Claims().GetString("name")
+}
+
+// PreferredUsername returns the "preferred_username" claim.
+func (t *IDToken) PreferredUsername() (string, bool) {
+	return t.Claims().GetString("preferred_username")
+}
+
+// EOF
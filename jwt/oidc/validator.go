@@ -0,0 +1,248 @@
+// Tideland Go Network - JSON Web Token - OpenID Connect
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package oidc // import "tideland.dev/go/net/jwt/oidc"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto"
+	"crypto/subtle"
+	"encoding/base64"
+	"time"
+
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CONFIGURATION
+//--------------------
+
+// Config contains the configuration of a Validator. Issuer and
+// ClientID as well as Key or KeyFunc are mandatory. Algorithms
+// default to RS256 and the leeway to one minute. A MaxAge enforces
+// the "auth_time" check, ACRValues restrict the accepted "acr".
+// Algorithm and key are checked before a token is verified, e.g.
+// the minimal size of RSA keys.
+type Config struct {
+	Issuer     string
+	ClientID   string
+	Key        token.Key
+	KeyFunc    token.KeyFunc
+	Algorithms []token.Algorithm
+	Leeway     time.Duration
+	MaxAge     time.Duration
+	ACRValues  []string
+}
+
+//--------------------
+// VALIDATOR
+//--------------------
+
+// Validator validates ID tokens issued for a client.
+type Validator struct {
+	issuer     string
+	clientID   string
+	keyFunc    token.KeyFunc
+	algorithms []token.Algorithm
+	leeway     time.Duration
+	maxAge     time.Duration
+	acrValues  []string
+}
+
+// NewValidator creates a validator for ID tokens.
+func NewValidator(config *Config) *Validator {
+	if config == nil || config.Issuer == "" || config.ClientID == "" {
+		panic("need issuer and client ID")
+	}
+	v := &Validator{
+		issuer:     config.Issuer,
+		clientID:   config.ClientID,
+		keyFunc:    config.KeyFunc,
+		algorithms: []token.Algorithm{token.RS256},
+		leeway:     time.Minute,
+		maxAge:     config.MaxAge,
+		acrValues:  config.ACRValues,
+	}
+	if v.keyFunc == nil {
+		if config.Key == nil {
+			panic("need key or key function")
+		}
+		key := config.Key
+		v.keyFunc = func(jwt *token.JWT) (token.Key, error) {
+			return key, nil
+		}
+	}
+	if len(config.Algorithms) > 0 {
+		v.algorithms = config.Algorithms
+	}
+	if config.Leeway != 0 {
+		v.leeway = config.Leeway
+	}
+	// Check algorithm and key before any verification.
+	keyFunc := token.StrictKeyFunc(v.keyFunc)
+	v.keyFunc = func(jwt *token.JWT) (token.Key, error) {
+		if !v.isAllowedAlgorithm(jwt.Algorithm()) {
+			return nil, failure.New("algorithm '%s' is not allowed", jwt.Algorithm())
+		}
+		return keyFunc(jwt)
+	}
+	return v
+}
+
+// Validate verifies the raw ID token and performs the checks of the
+// issuer, audience, authorized party, times, nonce, authentication
+// context class, and authentication time. An empty nonce is not
+// checked, e.g. for refreshed ID tokens.
+func (v *Validator) Validate(st, nonce string) (*IDToken, error) {
+	jwt, err := token.VerifyWithKeyFunc(st, v.keyFunc)
+	if err != nil {
+		return nil, failure.Annotate(err, "invalid ID token")
+	}
+	idt := &IDToken{jwt: jwt}
+	claims := jwt.Claims()
+	// Issuer and subject.
+	if iss, ok := claims.Issuer(); !ok || iss != v.issuer {
+		return nil, failure.New("invalid ID token: issuer %q does not match", iss)
+	}
+	if sub, ok := claims.Subject(); !ok || sub == "" {
+		return nil, failure.New("invalid ID token: subject is missing")
+	}
+	// Audience and authorized party.
+	auds, ok := claims.Audience()
+	if !ok || !contains(auds, v.clientID) {
+		return nil, failure.New("invalid ID token: audience does not contain client ID")
+	}
+	azp, ok := idt.AuthorizedParty()
+	if len(auds) > 1 && !ok {
+		return nil, failure.New("invalid ID token: authorized party is missing for multiple audiences")
+	}
+	if ok && azp != v.clientID {
+		return nil, failure.New("invalid ID token: authorized party %q does not match", azp)
+	}
+	// Times.
+	if !claims.Contains("exp") || !claims.IsStillValid(v.leeway) {
+		return nil, failure.New("invalid ID token: token is expired")
+	}
+	iat, ok := claims.IssuedAt()
+	if !ok {
+		return nil, failure.New("invalid ID token: issued at is missing")
+	}
+	now := time.Now()
+	if iat.After(now.Add(v.leeway)) {
+		return nil, failure.New("invalid ID token: issued at is in the future")
+	}
+	if !claims.IsAlreadyValid(v.leeway) {
+		return nil, failure.New("invalid ID token: token is not yet valid")
+	}
+	// Nonce.
+	if nonce != "" {
+		tnonce, _ := idt.Nonce()
+		if subtle.ConstantTimeCompare([]byte(tnonce), []byte(nonce)) != 1 {
+			return nil, failure.New("invalid ID token: nonce does not match")
+		}
+	}
+	// Authentication context class.
+	if len(v.acrValues) > 0 {
+		acr, ok := idt.AuthContextClass()
+		if !ok || !contains(v.acrValues, acr) {
+			return nil, failure.New("invalid ID token: authentication context class %q is not accepted", acr)
+		}
+	}
+	// Authentication time.
+	if v.maxAge > 0 {
+		authTime, ok := idt.AuthTime()
+		if !ok {
+			return nil, failure.New("invalid ID token: authentication time is missing")
+		}
+		if now.Sub(authTime) > v.maxAge+v.leeway {
+			return nil, failure.New("invalid ID token: authentication is too old")
+		}
+	}
+	return idt, nil
+}
+
+// ValidateAccessToken checks if the "at_hash" claim of the ID
+// token matches the access token issued with it.
+func (v *Validator) ValidateAccessToken(idt *IDToken, accessToken string) error {
+	atHash, ok := idt.AccessTokenHash()
+	if !ok {
+		return failure.New("ID token contains no access token hash")
+	}
+	return validateHash(idt.JWT().Algorithm(), atHash, accessToken)
+}
+
+// ValidateCode checks if the "c_hash" claim of the ID token
+// matches the authorization code issued with it.
+func (v *Validator) ValidateCode(idt *IDToken, code string) error {
+	cHash, ok := idt.CodeHash()
+	if !ok {
+		return failure.New("ID token contains no code hash")
+	}
+	return validateHash(idt.JWT().Algorithm(), cHash, code)
+}
+
+// isAllowedAlgorithm checks if the algorithm is allowed.
+func (v *Validator) isAllowedAlgorithm(algorithm token.Algorithm) bool {
+	for _, allowed := range v.algorithms {
+		if allowed == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// TokenHash calculates the value of the "at_hash" or "c_hash" claim
+// for a value and the algorithm of the ID token. It is the left half
+// of the hash in BASE64 URL encoding. EdDSA uses SHA-512 like
+// defined for Ed25519.
+func TokenHash(algorithm token.Algorithm, value string) (string, error) {
+	h := algorithm.Hash()
+	if algorithm == token.EdDSA {
+		h = crypto.SHA512
+	}
+	if h == 0 || !h.Available() {
+		return "", failure.New("algorithm '%s' has no hash", algorithm)
+	}
+	hasher := h.New()
+	if _, err := hasher.Write([]byte(value)); err != nil {
+		return "", err
+	}
+	sum := hasher.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// validateHash compares a hash claim with the calculated one.
+func validateHash(algorithm token.Algorithm, claimed, value string) error {
+	expected, err := TokenHash(algorithm, value)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(claimed), []byte(expected)) != 1 {
+		return failure.New("hash does not match")
+	}
+	return nil
+}
+
+// contains checks if the value is part of the values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -0,0 +1,280 @@
+// Tideland Go Network - JSON Web Token - OpenID Connect - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package oidc_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/oidc"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+const (
+	issuer   = "https://idp.example.com"
+	clientID = "client-4711"
+	nonce    = "n-0S6_WzA2Mj"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestValidate tests the validation steps for ID tokens.
+func TestValidate(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	v := oidc.NewValidator(&oidc.Config{
+		Issuer:    issuer,
+		ClientID:  clientID,
+		Key:       key.Public(),
+		MaxAge:    time.Hour,
+		ACRValues: []string{"urn:mace:incommon:iap:silver"},
+	})
+	now := time.Now()
+
+	tests := []struct {
+		description string
+		modify      func(c token.Claims)
+		algorithm   token.Algorithm
+		err         string
+	}{
+		{"valid token", func(c token.Claims) {}, token.RS256, ""},
+		{"wrong issuer", func(c token.Claims) {
+			c.SetIssuer("https://other.example.com")
+		}, token.RS256, ".*issuer .* does not match.*"},
+		{"missing subject", func(c token.Claims) {
+			c.DeleteSubject()
+		}, token.RS256, ".*subject is missing.*"},
+		{"wrong audience", func(c token.Claims) {
+			c.SetAudience("other")
+		}, token.RS256, ".*audience does not contain client ID.*"},
+		{"multiple audiences without azp", func(c token.Claims) {
+			c.SetAudience(clientID, "other")
+		}, token.RS256, ".*authorized party is missing.*"},
+		{"multiple audiences with azp", func(c token.Claims) {
+			c.SetAudience(clientID, "other")
+			c.Set("azp", clientID)
+		}, token.RS256, ""},
+		{"wrong azp", func(c token.Claims) {
+			c.Set("azp", "other")
+		}, token.RS256, ".*authorized party .* does not match.*"},
+		{"expired", func(c token.Claims) {
+			c.SetExpiration(now.Add(-time.Hour))
+		}, token.RS256, ".*token is expired.*"},
+		{"missing expiration", func(c token.Claims) {
+			c.DeleteExpiration()
+		}, token.RS256, ".*token is expired.*"},
+		{"missing issued at", func(c token.Claims) {
+			c.DeleteIssuedAt()
+		}, token.RS256, ".*issued at is missing.*"},
+		{"issued in the future", func(c token.Claims) {
+			c.SetIssuedAt(now.Add(time.Hour))
+		}, token.RS256, ".*issued at is in the future.*"},
+		{"wrong nonce", func(c token.Claims) {
+			c.Set("nonce", "other")
+		}, token.RS256, ".*nonce does not match.*"},
+		{"wrong acr", func(c token.Claims) {
+			c.Set("acr", "urn:mace:incommon:iap:bronze")
+		}, token.RS256, ".*authentication context class .* is not accepted.*"},
+		{"old authentication", func(c token.Claims) {
+			c.SetTime("auth_time", now.Add(-2*time.Hour))
+		}, token.RS256, ".*authentication is too old.*"},
+		{"missing authentication time", func(c token.Claims) {
+			c.Delete("auth_time")
+		}, token.RS256, ".*authentication time is missing.*"},
+		{"not allowed algorithm", func(c token.Claims) {}, token.PS256, ".*algorithm 'PS256' is not allowed.*"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.description)
+		claims := idTokenClaims(now)
+		test.modify(claims)
+		jwt, err := token.Encode(claims, key, test.algorithm)
+		assert.NoError(err)
+		idt, err := v.Validate(jwt.String(), nonce)
+		if test.err == "" {
+			assert.NoError(err)
+			assert.Equal(idt.Subject(), "248289761001")
+		} else {
+			assert.ErrorMatch(err, test.err)
+			assert.Nil(idt)
+		}
+	}
+}
+
+// TestValidateKeyChecks tests the checking of algorithm and key
+// before the verification.
+func TestValidateKeyChecks(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+	calls := 0
+	v := oidc.NewValidator(&oidc.Config{
+		Issuer:   issuer,
+		ClientID: clientID,
+		KeyFunc: func(jwt *token.JWT) (token.Key, error) {
+			calls++
+			return key.Public(), nil
+		},
+	})
+
+	// Not allowed algorithms do not reach the key function.
+	jwt, err := token.Encode(idTokenClaims(time.Now()), key, token.PS256)
+	assert.NoError(err)
+	_, err = v.Validate(jwt.String(), nonce)
+	assert.ErrorMatch(err, ".*algorithm 'PS256' is not allowed.*")
+	assert.Equal(calls, 0)
+
+	// Weak keys are rejected.
+	v = oidc.NewValidator(&oidc.Config{
+		Issuer:   issuer,
+		ClientID: clientID,
+		Key:      weakKey.Public(),
+	})
+	jwt, err = token.Encode(idTokenClaims(time.Now()), weakKey, token.RS256)
+	assert.NoError(err)
+	_, err = v.Validate(jwt.String(), nonce)
+	assert.ErrorMatch(err, ".*needs at least 2048 bits.*")
+}
+
+// TestValidateHashes tests the validation of access token
+// and code hashes.
+func TestValidateHashes(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	v := oidc.NewValidator(&oidc.Config{
+		Issuer:   issuer,
+		ClientID: clientID,
+		Key:      key.Public(),
+	})
+	// Example of OpenID Connect Core A.3.
+	atHash, err := oidc.TokenHash(token.RS256, "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y")
+	assert.NoError(err)
+	assert.Equal(atHash, "77QmUPtjPfzWtF2AnpK9RQ")
+	cHash, err := oidc.TokenHash(token.RS256, "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk")
+	assert.NoError(err)
+	assert.Equal(cHash, "LDktKdoQak3Pk0cnXxCltA")
+
+	claims := idTokenClaims(time.Now())
+	claims.Set("at_hash", atHash)
+	claims.Set("c_hash", cHash)
+	jwt, err := token.Encode(claims, key, token.RS256)
+	assert.NoError(err)
+	idt, err := v.Validate(jwt.String(), "")
+	assert.NoError(err)
+	assert.NoError(v.ValidateAccessToken(idt, "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y"))
+	assert.ErrorMatch(v.ValidateAccessToken(idt, "other"), ".*hash does not match.*")
+	assert.NoError(v.ValidateCode(idt, "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"))
+	assert.ErrorMatch(v.ValidateCode(idt, "other"), ".*hash does not match.*")
+
+	// EdDSA uses SHA-512.
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(err)
+	v = oidc.NewValidator(&oidc.Config{
+		Issuer:     issuer,
+		ClientID:   clientID,
+		Key:        pub,
+		Algorithms: []token.Algorithm{token.EdDSA},
+	})
+	sum := sha512.Sum512([]byte("access-token"))
+	atHash, err = oidc.TokenHash(token.EdDSA, "access-token")
+	assert.NoError(err)
+	assert.Equal(atHash, base64.RawURLEncoding.EncodeToString(sum[:32]))
+	claims = idTokenClaims(time.Now())
+	claims.Set("at_hash", atHash)
+	jwt, err = token.Encode(claims, priv, token.EdDSA)
+	assert.NoError(err)
+	idt, err = v.Validate(jwt.String(), "")
+	assert.NoError(err)
+	assert.NoError(v.ValidateAccessToken(idt, "access-token"))
+}
+
+// TestIDTokenAccessors tests the typed access to the claims.
+func TestIDTokenAccessors(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte("a-secret-long-enough-for-hs256-usage")
+	v := oidc.NewValidator(&oidc.Config{
+		Issuer:     issuer,
+		ClientID:   clientID,
+		Key:        key,
+		Algorithms: []token.Algorithm{token.HS256},
+	})
+	now := time.Now()
+	claims := idTokenClaims(now)
+	claims.Set("email", "jane.doe@example.com")
+	claims.Set("email_verified", true)
+	claims.Set("name", "Jane Doe")
+	claims.Set("preferred_username", "j.doe")
+	claims.Set("amr", []string{"pwd", "otp"})
+	jwt, err := token.Encode(claims, key, token.HS256)
+	assert.NoError(err)
+	idt, err := v.Validate(jwt.String(), nonce)
+	assert.NoError(err)
+
+	assert.Equal(idt.Issuer(), issuer)
+	assert.Equal(idt.Audience(), []string{clientID})
+	amr, ok := idt.AuthMethods()
+	assert.True(ok)
+	assert.Equal(amr, []string{"pwd", "otp"})
+	email, ok := idt.Email()
+	assert.True(ok)
+	assert.Equal(email, "jane.doe@example.com")
+	verified, ok := idt.EmailVerified()
+	assert.True(ok)
+	assert.True(verified)
+	name, ok := idt.Name()
+	assert.True(ok)
+	assert.Equal(name, "Jane Doe")
+	username, ok := idt.PreferredUsername()
+	assert.True(ok)
+	assert.Equal(username, "j.doe")
+	authTime, ok := idt.AuthTime()
+	assert.True(ok)
+	assert.Equal(authTime.Unix(), now.Add(-time.Minute).Unix())
+	acr, ok := idt.AuthContextClass()
+	assert.True(ok)
+	assert.Equal(acr, "urn:mace:incommon:iap:silver")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// idTokenClaims creates the claims of a valid ID token.
+func idTokenClaims(now time.Time) token.Claims {
+	claims := token.NewClaims()
+	claims.SetIssuer(issuer)
+	claims.SetSubject("248289761001")
+	claims.SetAudience(clientID)
+	claims.SetIssuedAt(now)
+	claims.SetExpiration(now.Add(10 * time.Minute))
+	claims.SetTime("auth_time", now.Add(-time.Minute))
+	claims.Set("nonce", nonce)
+	claims.Set("acr", "urn:mace:incommon:iap:silver")
+	return claims
+}
+
+// EOF
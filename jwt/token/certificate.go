@@ -0,0 +1,119 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// CERTIFICATES
+//--------------------
+
+// Certificates returns the parsed X.509 certificate chain of the
+// "x5c" header. The first certificate is the one of the signing key.
+func (jwt *JWT) Certificates() ([]*x509.Certificate, error) {
+	if len(jwt.header.X509CertChain) == 0 {
+		return nil, failure.New("token contains no certificate chain")
+	}
+	var certs []*x509.Certificate
+	for i, encoded := range jwt.header.X509CertChain {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode certificate %d", i)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot parse certificate %d", i)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// X509Options control the verification of the certificate chain
+// contained in the "x5c" header. Roots are needed, the other values
+// are optional. Without KeyUsages any extended key usage is accepted,
+// a zero CurrentTime means now.
+type X509Options struct {
+	Roots       *x509.CertPool
+	KeyUsages   []x509.ExtKeyUsage
+	CurrentTime time.Time
+}
+
+// X509KeyFunc returns a key function validating the certificate chain
+// of the "x5c" header against the roots. If the chain is valid, the
+// leaf certificate may be used for digital signatures, and it matches
+// an optional "x5t#S256" header the key of the leaf is returned for
+// the verification of the token. Like with StrictKeyFunc the key has
+// to fit the algorithm of the token.
+func X509KeyFunc(options *X509Options) KeyFunc {
+	if options == nil || options.Roots == nil {
+		panic("need root certificates")
+	}
+	keyUsages := options.KeyUsages
+	if len(keyUsages) == 0 {
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+	return StrictKeyFunc(func(jwt *JWT) (Key, error) {
+		certs, err := jwt.Certificates()
+		if err != nil {
+			return nil, err
+		}
+		leaf := certs[0]
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         options.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   options.CurrentTime,
+			KeyUsages:     keyUsages,
+		})
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot verify the certificate chain")
+		}
+		if leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+			return nil, failure.New("certificate is not valid for digital signatures")
+		}
+		if thumbprint := jwt.header.X509SHA256Thumbprint; thumbprint != "" {
+			if thumbprint != CertificateThumbprint(leaf) {
+				return nil, failure.New("certificate does not match the thumbprint")
+			}
+		}
+		return leaf.PublicKey, nil
+	})
+}
+
+// VerifyX509 verifies the token with the key of the certificate chain
+// of its "x5c" header after validating the chain against the roots.
+func VerifyX509(token string, roots *x509.CertPool) (*JWT, error) {
+	return VerifyWithKeyFunc(token, X509KeyFunc(&X509Options{
+		Roots: roots,
+	}))
+}
+
+// CertificateThumbprint returns the BASE64 URL encoded SHA-256
+// thumbprint of the DER encoded certificate as used by the "x5t#S256"
+// header and the same named member of the "cnf" claim.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// EOF
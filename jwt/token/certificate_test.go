@@ -0,0 +1,208 @@
+// Tideland Go Network - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestVerifyX509 tests the verification of tokens with the
+// certificate chain in the header.
+func TestVerifyX509(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	now := time.Now()
+	root := newTestCert(assert, "root", nil, true, now.Add(time.Hour), 0)
+	intermediate := newTestCert(assert, "intermediate", root, true, now.Add(time.Hour), 0)
+	leaf := newTestCert(assert, "leaf", intermediate, false, now.Add(time.Hour), x509.KeyUsageDigitalSignature)
+	expired := newTestCert(assert, "expired", intermediate, false, now.Add(-time.Minute), x509.KeyUsageDigitalSignature)
+	encipher := newTestCert(assert, "encipher", intermediate, false, now.Add(time.Hour), x509.KeyUsageKeyEncipherment)
+	other := newTestCert(assert, "other", nil, true, now.Add(time.Hour), 0)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	tests := []struct {
+		description string
+		signer      *testCert
+		chain       []*testCert
+		thumbprint  string
+		err         string
+	}{
+		{"valid chain", leaf, []*testCert{leaf, intermediate}, "", ""},
+		{"valid thumbprint", leaf, []*testCert{leaf, intermediate}, thumbprint(leaf), ""},
+		{"invalid thumbprint", leaf, []*testCert{leaf, intermediate}, thumbprint(intermediate), ".*does not match the thumbprint.*"},
+		{"no chain", leaf, nil, "", ".*contains no certificate chain.*"},
+		{"missing intermediate", leaf, []*testCert{leaf}, "", ".*cannot verify the certificate chain.*"},
+		{"unknown root", other, []*testCert{other}, "", ".*cannot verify the certificate chain.*"},
+		{"expired leaf", expired, []*testCert{expired, intermediate}, "", ".*cannot verify the certificate chain.*"},
+		{"wrong key usage", encipher, []*testCert{encipher, intermediate}, "", ".*not valid for digital signatures.*"},
+		{"wrong signer", other, []*testCert{leaf, intermediate}, "", ".*signature is invalid.*"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.description)
+		header := token.Header{
+			Algorithm:            token.ES256,
+			Type:                 "JWT",
+			X509SHA256Thumbprint: test.thumbprint,
+		}
+		for _, c := range test.chain {
+			header.X509CertChain = append(header.X509CertChain, base64.StdEncoding.EncodeToString(c.cert.Raw))
+		}
+		claims := token.NewClaims()
+		claims.SetSubject("partner")
+		jwt, err := token.EncodeWithHeader(claims, test.signer.key, header)
+		assert.Nil(err)
+		verified, err := token.VerifyX509(jwt.String(), roots)
+		if test.err != "" {
+			assert.ErrorMatch(err, test.err)
+			continue
+		}
+		assert.Nil(err)
+		sub, ok := verified.Claims().Subject()
+		assert.True(ok)
+		assert.Equal(sub, "partner")
+		certs, err := verified.Certificates()
+		assert.Nil(err)
+		assert.Length(certs, 2)
+		assert.Equal(certs[0].Subject.CommonName, "leaf")
+		assert.Equal(token.CertificateThumbprint(certs[0]), thumbprint(leaf))
+	}
+	// Check with time and key usage options.
+	jwt, err := token.EncodeWithHeader(token.NewClaims(), leaf.key, token.Header{
+		Algorithm:     token.ES256,
+		X509CertChain: []string{base64.StdEncoding.EncodeToString(leaf.cert.Raw), base64.StdEncoding.EncodeToString(intermediate.cert.Raw)},
+	})
+	assert.Nil(err)
+	_, err = token.VerifyWithKeyFunc(jwt.String(), token.X509KeyFunc(&token.X509Options{
+		Roots:       roots,
+		CurrentTime: now.Add(2 * time.Hour),
+	}))
+	assert.ErrorMatch(err, ".*cannot verify the certificate chain.*")
+	_, err = token.VerifyWithKeyFunc(jwt.String(), token.X509KeyFunc(&token.X509Options{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}))
+	assert.ErrorMatch(err, ".*cannot verify the certificate chain.*")
+	assert.Panics(func() {
+		token.X509KeyFunc(nil)
+	})
+}
+
+// TestVerifyX509Keys tests the validation of the certificate key for
+// the algorithm of the token.
+func TestVerifyX509Keys(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	now := time.Now()
+	root := newTestCert(assert, "root", nil, true, now.Add(time.Hour), 0)
+	leaf := newTestCert(assert, "leaf", root, false, now.Add(time.Hour), x509.KeyUsageDigitalSignature)
+	roots := x509.NewCertPool()
+	roots.AddCert(root.cert)
+
+	// Curve not matching the algorithm.
+	jwt, err := token.EncodeWithHeader(token.NewClaims(), leaf.key, token.Header{
+		Algorithm:     token.ES384,
+		X509CertChain: []string{base64.StdEncoding.EncodeToString(leaf.cert.Raw)},
+	})
+	assert.Nil(err)
+	_, err = token.VerifyX509(jwt.String(), roots)
+	assert.ErrorMatch(err, ".*curve does not match.*")
+
+	// Too short RSA key.
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(4711),
+		Subject:      pkix.Name{CommonName: "weak"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root.cert, key.Public(), root.key)
+	assert.Nil(err)
+	jwt, err = token.EncodeWithHeader(token.NewClaims(), key, token.Header{
+		Algorithm:     token.RS256,
+		X509CertChain: []string{base64.StdEncoding.EncodeToString(der)},
+	})
+	assert.Nil(err)
+	_, err = token.VerifyX509(jwt.String(), roots)
+	assert.ErrorMatch(err, ".*needs at least 2048 bits.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// testCert contains a certificate and its private key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate signed by the parent or, if nil,
+// a self-signed one.
+func newTestCert(assert *asserts.Asserts, cn string, parent *testCert, ca bool, notAfter time.Time, usage x509.KeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(err)
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              usage,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	issuer := template
+	var signer crypto.Signer = key
+	if parent != nil {
+		issuer = parent.cert
+		signer = parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	assert.Nil(err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(err)
+	return &testCert{cert, key}
+}
+
+// thumbprint returns the SHA-256 thumbprint of the certificate.
+func thumbprint(c *testCert) string {
+	sum := sha256.Sum256(c.cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// EOF
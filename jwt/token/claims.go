@@ -259,6 +259,32 @@ func (c Claims) DeleteAudience() []string {
 	return old
 }
 
+// Confirmation contains the members of the "cnf" claim (RFC 7800)
+// binding a token to a key. These are the JWK thumbprint of a DPoP
+// key (RFC 9449) or the thumbprint of a client certificate (RFC 8705).
+type Confirmation struct {
+	JWKThumbprint  string `json:"jkt,omitempty"`
+	X509Thumbprint string `json:"x5t#S256,omitempty"`
+}
+
+// Confirmation retrieves the "cnf" claim.
+func (c Claims) Confirmation() (Confirmation, bool) {
+	var cnf Confirmation
+	ok, err := c.GetMarshalled("cnf", &cnf)
+	if !ok || err != nil {
+		return Confirmation{}, false
+	}
+	return cnf, true
+}
+
+// SetConfirmation sets the "cnf" claim. It returns a potential
+// old value.
+func (c Claims) SetConfirmation(cnf Confirmation) Confirmation {
+	old, _ := c.Confirmation()
+	c.Set("cnf", cnf)
+	return old
+}
+
 // Expiration retrieves the reserved "exp" claim.
 func (c Claims) Expiration() (time.Time, bool) {
 	return c.GetTime("exp")
@@ -14,6 +14,8 @@ package token // import "tideland.dev/go/net/jwt/token"
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
@@ -47,6 +49,7 @@ const (
 	ES256 Algorithm = "ES256"
 	ES384 Algorithm = "ES384"
 	ES512 Algorithm = "ES512"
+	EdDSA Algorithm = "EdDSA"
 	HS256 Algorithm = "HS256"
 	HS384 Algorithm = "HS384"
 	HS512 Algorithm = "HS512"
@@ -59,12 +62,6 @@ const (
 	NONE  Algorithm = "none"
 )
 
-// ecPoint is needed to marshal R and S of the ECDSA algorithms.
-type ecPoint struct {
-	R *big.Int
-	S *big.Int
-}
-
 // Sign creates the signature for the data based on the
 // algorithm and the key.
 func (a Algorithm) Sign(data []byte, key Key) (Signature, error) {
@@ -75,7 +72,7 @@ func (a Algorithm) Sign(data []byte, key Key) (Signature, error) {
 		return a.sign(data, key, crypto.SHA384)
 	case ES512, HS512, PS512, RS512:
 		return a.sign(data, key, crypto.SHA512)
-	case NONE:
+	case EdDSA, NONE:
 		return a.sign(data, key, 0)
 	default:
 		return nil, failure.New("signing algorithm '%s' is invalid", a)
@@ -92,13 +89,35 @@ func (a Algorithm) Verify(data []byte, sig Signature, key Key) error {
 		return a.verify(data, sig, key, crypto.SHA384)
 	case ES512, HS512, PS512, RS512:
 		return a.verify(data, sig, key, crypto.SHA512)
-	case NONE:
+	case EdDSA, NONE:
 		return a.verify(data, sig, key, 0)
 	default:
 		return failure.New("verifying algorithm '%s' is invalid", a)
 	}
 }
 
+// Hash returns the hash function used by the algorithm. It is
+// zero for the algorithms "EdDSA" and "none" as well as for
+// invalid algorithms.
+func (a Algorithm) Hash() crypto.Hash {
+	switch a {
+	case ES256, HS256, PS256, RS256:
+		return crypto.SHA256
+	case ES384, HS384, PS384, RS384:
+		return crypto.SHA384
+	case ES512, HS512, PS512, RS512:
+		return crypto.SHA512
+	default:
+		return 0
+	}
+}
+
+// isECDSA returns true when the algorithm is one of
+// the ECDSA algorithms.
+func (a Algorithm) isECDSA() bool {
+	return a == ES256 || a == ES384 || a == ES512
+}
+
 // isRSAPSS returns true when the algorithm is one of
 // the RSAPSS algorithms.
 func (a Algorithm) isRSAPSS() bool {
@@ -111,6 +130,9 @@ func (a Algorithm) sign(data []byte, k Key, h crypto.Hash) (Signature, error) {
 	case *ecdsa.PrivateKey:
 		// ECDSA algorithms.
 		return a.signECDSA(data, key, h)
+	case ed25519.PrivateKey:
+		// EdDSA algorithm.
+		return a.signEdDSA(data, key)
 	case []byte:
 		// HMAC algorithms.
 		return a.signHMAC(data, key, h)
@@ -123,6 +145,12 @@ func (a Algorithm) sign(data []byte, k Key, h crypto.Hash) (Signature, error) {
 			return nil, failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "none")
 		}
 		return Signature(""), nil
+	case Signer:
+		// External signer.
+		return key.SignData(data, a)
+	case crypto.Signer:
+		// Other implementations of the standard signer.
+		return NewCryptoSigner(key).SignData(data, a)
 	default:
 		// No valid key type.
 		return nil, failure.New("key type %T is invalid", k)
@@ -131,18 +159,25 @@ func (a Algorithm) sign(data []byte, k Key, h crypto.Hash) (Signature, error) {
 
 // signECDSA signs the data using the ECDSA algorithm.
 func (a Algorithm) signECDSA(data []byte, key *ecdsa.PrivateKey, h crypto.Hash) (Signature, error) {
-	if a[0] != 'E' {
+	if !a.isECDSA() {
 		return nil, failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "ECDSA")
 	}
 	r, s, err := ecdsa.Sign(rand.Reader, key, hashSum(data, h))
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot sign the data")
 	}
-	sig, err := asn1.Marshal(ecPoint{r, s})
-	if err != nil {
-		return nil, failure.Annotate(err, "cannot sign the data")
+	return encodeECDSASignature(key.Curve, r, s), nil
+}
+
+// signEdDSA signs the data using the EdDSA algorithm.
+func (a Algorithm) signEdDSA(data []byte, key ed25519.PrivateKey) (Signature, error) {
+	if a != EdDSA {
+		return nil, failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "Ed25519")
 	}
-	return Signature(sig), nil
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, failure.New("Ed25519 private key is invalid")
+	}
+	return Signature(ed25519.Sign(key, data)), nil
 }
 
 // signHMAC signs the data using the HMAC algorithm.
@@ -190,6 +225,9 @@ func (a Algorithm) verify(data []byte, sig Signature, k Key, h crypto.Hash) erro
 	case *ecdsa.PublicKey:
 		// ECDSA algorithms.
 		return a.verifyECDSA(data, sig, key, h)
+	case ed25519.PublicKey:
+		// EdDSA algorithm.
+		return a.verifyEdDSA(data, sig, key)
 	case []byte:
 		// HMAC algorithms.
 		return a.verifyHMAC(data, sig, key, h)
@@ -205,6 +243,9 @@ func (a Algorithm) verify(data []byte, sig Signature, k Key, h crypto.Hash) erro
 			return failure.New("data signature is invalid")
 		}
 		return nil
+	case Verifier:
+		// External verifier.
+		return key.VerifyData(data, sig, a)
 	default:
 		// No valid key type.
 		return failure.New("key type %T is invalid", k)
@@ -213,14 +254,28 @@ func (a Algorithm) verify(data []byte, sig Signature, k Key, h crypto.Hash) erro
 
 // verifyECDSA verifies the data using the ECDSA algorithm.
 func (a Algorithm) verifyECDSA(data []byte, sig Signature, key *ecdsa.PublicKey, h crypto.Hash) error {
-	if a[0] != 'E' {
+	if !a.isECDSA() {
 		return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "ECDSA")
 	}
-	var ecp ecPoint
-	if _, err := asn1.Unmarshal(sig, &ecp); err != nil {
+	r, s, err := decodeECDSASignature(key.Curve, sig)
+	if err != nil {
 		return failure.Annotate(err, "cannot verify the data")
 	}
-	if !ecdsa.Verify(key, hashSum(data, h), ecp.R, ecp.S) {
+	if !ecdsa.Verify(key, hashSum(data, h), r, s) {
+		return failure.New("data signature is invalid")
+	}
+	return nil
+}
+
+// verifyEdDSA verifies the data using the EdDSA algorithm.
+func (a Algorithm) verifyEdDSA(data []byte, sig Signature, key ed25519.PublicKey) error {
+	if a != EdDSA {
+		return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "Ed25519")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return failure.New("Ed25519 public key is invalid")
+	}
+	if !ed25519.Verify(key, data, sig) {
 		return failure.New("data signature is invalid")
 	}
 	return nil
@@ -277,4 +332,32 @@ func hashSum(data []byte, h crypto.Hash) []byte {
 	return hasher.Sum(nil)
 }
 
+// encodeECDSASignature returns R and S as concatenated big-endian
+// values with the byte size of the curve as defined in RFC 7518.
+func encodeECDSASignature(curve elliptic.Curve, r, s *big.Int) Signature {
+	size := (curve.Params().BitSize + 7) / 8
+	rb, sb := r.Bytes(), s.Bytes()
+	sig := make([]byte, 2*size)
+	copy(sig[size-len(rb):size], rb)
+	copy(sig[2*size-len(sb):], sb)
+	return Signature(sig)
+}
+
+// decodeECDSASignature returns R and S of a signature encoded as
+// defined in RFC 7518. For compatibility with tokens signed by
+// earlier versions of this package ASN.1 DER is accepted too.
+func decodeECDSASignature(curve elliptic.Curve, sig Signature) (*big.Int, *big.Int, error) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(sig) != 2*size {
+		var ecp ecPoint
+		if rest, err := asn1.Unmarshal(sig, &ecp); err == nil && len(rest) == 0 {
+			return ecp.R, ecp.S, nil
+		}
+		return nil, nil, failure.New("ECDSA signature size is invalid")
+	}
+	r := new(big.Int).SetBytes(sig[:size])
+	s := new(big.Int).SetBytes(sig[size:])
+	return r, s, nil
+}
+
 // EOF
@@ -0,0 +1,221 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// DPOP
+//--------------------
+
+// DPoPType is the token type of DPoP proofs.
+const DPoPType = "dpop+jwt"
+
+// DPoPProver creates proofs of the possession of a key as defined by
+// RFC 9449 (DPoP). They bind the request to the key and, if passed,
+// to the access token.
+type DPoPProver struct {
+	key        Key
+	algorithm  Algorithm
+	jwk        *JWK
+	thumbprint string
+}
+
+// NewDPoPProver creates a prover for the private key or Signer. The
+// algorithm has to be an asymmetric one.
+func NewDPoPProver(key Key, algorithm Algorithm) (*DPoPProver, error) {
+	if !isAsymmetric(algorithm) {
+		return nil, failure.New("algorithm '%s' cannot be used for DPoP", algorithm)
+	}
+	if err := algorithm.ValidateKey(key); err != nil {
+		return nil, failure.Annotate(err, "cannot use key for DPoP")
+	}
+	jwk, err := NewJWK(key)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot use key for DPoP")
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot use key for DPoP")
+	}
+	return &DPoPProver{
+		key:        key,
+		algorithm:  algorithm,
+		jwk:        jwk,
+		thumbprint: thumbprint,
+	}, nil
+}
+
+// Thumbprint returns the JWK thumbprint of the key. Authorization
+// servers use it as "jkt" member of the "cnf" claim.
+func (p *DPoPProver) Thumbprint() string {
+	return p.thumbprint
+}
+
+// Proof creates a proof for a request with method and URL. If the
+// access token isn't empty the proof is bound to it.
+func (p *DPoPProver) Proof(method, rawurl, accessToken string) (string, error) {
+	htu, err := normalizeHTU(rawurl)
+	if err != nil {
+		return "", err
+	}
+	jti := make([]byte, 16)
+	if _, err = rand.Read(jti); err != nil {
+		return "", failure.Annotate(err, "cannot create proof identifier")
+	}
+	claims := NewClaims()
+	claims.SetIdentifier(hex.EncodeToString(jti))
+	claims.Set("htm", method)
+	claims.Set("htu", htu)
+	claims.SetIssuedAt(time.Now())
+	if accessToken != "" {
+		claims.Set("ath", accessTokenHash(accessToken))
+	}
+	jwt, err := EncodeWithHeader(claims, p.key, Header{
+		Algorithm: p.algorithm,
+		Type:      DPoPType,
+		JWK:       p.jwk,
+	})
+	if err != nil {
+		return "", failure.Annotate(err, "cannot create proof")
+	}
+	return jwt.String(), nil
+}
+
+// Authorize adds the access token with the scheme "DPoP" and a
+// matching proof to the request.
+func (p *DPoPProver) Authorize(req *http.Request, accessToken string) error {
+	proof, err := p.Proof(req.Method, req.URL.String(), accessToken)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "DPoP "+accessToken)
+	req.Header.Set("DPoP", proof)
+	return nil
+}
+
+// VerifyDPoPProof verifies a DPoP proof for a request with method and
+// URL. Its signature is checked with the key of the header, its "iat"
+// claim may differ by maxAge from now. If the access token isn't empty
+// the proof has to be bound to it. The proof and the JWK thumbprint
+// of its key are returned. Detecting replayed proofs by their
+// identifier is task of the caller.
+func VerifyDPoPProof(proof, method, rawurl, accessToken string, maxAge time.Duration) (*JWT, string, error) {
+	decoded, err := Decode(proof)
+	if err != nil {
+		return nil, "", failure.Annotate(err, "cannot verify the DPoP proof")
+	}
+	header := decoded.Header()
+	if header.Type != DPoPType {
+		return nil, "", failure.New("DPoP proof type '%s' is invalid", header.Type)
+	}
+	if !isAsymmetric(header.Algorithm) {
+		return nil, "", failure.New("DPoP proof algorithm '%s' is invalid", header.Algorithm)
+	}
+	if header.JWK == nil || header.JWK.KeyType == "oct" {
+		return nil, "", failure.New("DPoP proof contains no public key")
+	}
+	key, err := header.JWK.Key()
+	if err != nil {
+		return nil, "", failure.Annotate(err, "cannot verify the DPoP proof")
+	}
+	jwt, err := VerifyStrict(proof, key)
+	if err != nil {
+		return nil, "", failure.Annotate(err, "cannot verify the DPoP proof")
+	}
+	// Check the claims.
+	claims := jwt.Claims()
+	if jti, ok := claims.Identifier(); !ok || jti == "" {
+		return nil, "", failure.New("DPoP proof claim 'jti' is missing")
+	}
+	if htm, _ := claims.GetString("htm"); htm != method {
+		return nil, "", failure.New("DPoP proof claim 'htm' does not match")
+	}
+	htu, _ := claims.GetString("htu")
+	expected, err := normalizeHTU(rawurl)
+	if err != nil {
+		return nil, "", err
+	}
+	if actual, err := normalizeHTU(htu); err != nil || actual != expected {
+		return nil, "", failure.New("DPoP proof claim 'htu' does not match")
+	}
+	iat, ok := claims.IssuedAt()
+	if !ok {
+		return nil, "", failure.New("DPoP proof claim 'iat' is missing")
+	}
+	if age := time.Since(iat); age > maxAge || age < -maxAge {
+		return nil, "", failure.New("DPoP proof claim 'iat' is not valid")
+	}
+	if accessToken != "" {
+		if ath, _ := claims.GetString("ath"); ath != accessTokenHash(accessToken) {
+			return nil, "", failure.New("DPoP proof claim 'ath' does not match")
+		}
+	}
+	thumbprint, err := header.JWK.Thumbprint()
+	if err != nil {
+		return nil, "", failure.Annotate(err, "cannot verify the DPoP proof")
+	}
+	return jwt, thumbprint, nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// isAsymmetric checks if the algorithm uses asymmetric keys.
+func isAsymmetric(algorithm Algorithm) bool {
+	switch algorithm {
+	case ES256, ES384, ES512, EdDSA, PS256, PS384, PS512, RS256, RS384, RS512:
+		return true
+	default:
+		return false
+	}
+}
+
+// accessTokenHash returns the hash of the access token as needed
+// for the "ath" claim.
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// normalizeHTU removes query and fragment from the URL and
+// normalizes scheme and host.
+func normalizeHTU(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", failure.New("DPoP URL '%s' is invalid", rawurl)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.User = nil
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// EOF
@@ -0,0 +1,169 @@
+// Tideland Go Network - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestDPoPProof tests the creation and verification of DPoP proofs.
+func TestDPoPProof(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := token.GenerateKey(token.ES256)
+	assert.NoError(err)
+	prover, err := token.NewDPoPProver(key, token.ES256)
+	assert.NoError(err)
+	jwk, err := token.NewJWK(key)
+	assert.NoError(err)
+	tp, err := jwk.Thumbprint()
+	assert.NoError(err)
+	assert.Equal(prover.Thumbprint(), tp)
+
+	url := "https://server.example.com/resource"
+	tests := []struct {
+		description  string
+		method       string
+		proofURL     string
+		accessToken  string
+		verifyMethod string
+		verifyURL    string
+		verifyToken  string
+		err          string
+	}{
+		{"valid proof", http.MethodGet, url, "", http.MethodGet, url, "", ""},
+		{"valid bound proof", http.MethodPost, url, "at", http.MethodPost, url, "at", ""},
+		{"query and fragment", http.MethodGet, url + "?q=1#f", "", http.MethodGet, "HTTPS://Server.Example.com/resource?x=2", "", ""},
+		{"wrong method", http.MethodPost, url, "", http.MethodGet, url, "", ".*claim 'htm' does not match.*"},
+		{"wrong URL", http.MethodGet, url + "/other", "", http.MethodGet, url, "", ".*claim 'htu' does not match.*"},
+		{"wrong access token", http.MethodGet, url, "at", http.MethodGet, url, "other", ".*claim 'ath' does not match.*"},
+		{"unbound access token", http.MethodGet, url, "", http.MethodGet, url, "at", ".*claim 'ath' does not match.*"},
+		{"invalid URL", http.MethodGet, url, "", http.MethodGet, "/resource", "", ".*DPoP URL '/resource' is invalid.*"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.description)
+		proof, err := prover.Proof(test.method, test.proofURL, test.accessToken)
+		assert.NoError(err)
+		jwt, jkt, err := token.VerifyDPoPProof(proof, test.verifyMethod, test.verifyURL, test.verifyToken, time.Minute)
+		if test.err != "" {
+			assert.ErrorMatch(err, test.err)
+			continue
+		}
+		assert.NoError(err)
+		assert.Equal(jkt, tp)
+		assert.Equal(jwt.Header().Type, token.DPoPType)
+		jti, ok := jwt.Claims().Identifier()
+		assert.True(ok)
+		assert.Length(jti, 32)
+	}
+
+	assert.Logf("testing invalid proofs")
+	claims := token.NewClaims()
+	claims.SetIdentifier("id")
+	claims.Set("htm", http.MethodGet)
+	claims.Set("htu", url)
+	claims.SetIssuedAt(time.Now().Add(-time.Hour))
+	proof, err := token.EncodeWithHeader(claims, key, token.Header{Algorithm: token.ES256, Type: token.DPoPType, JWK: jwk})
+	assert.NoError(err)
+	_, _, err = token.VerifyDPoPProof(proof.String(), http.MethodGet, url, "", time.Minute)
+	assert.ErrorMatch(err, ".*claim 'iat' is not valid.*")
+	claims.SetIssuedAt(time.Now())
+	proof, err = token.EncodeWithHeader(claims, key, token.Header{Algorithm: token.ES256, Type: "JWT", JWK: jwk})
+	assert.NoError(err)
+	_, _, err = token.VerifyDPoPProof(proof.String(), http.MethodGet, url, "", time.Minute)
+	assert.ErrorMatch(err, ".*proof type 'JWT' is invalid.*")
+	proof, err = token.EncodeWithHeader(claims, key, token.Header{Algorithm: token.ES256, Type: token.DPoPType})
+	assert.NoError(err)
+	_, _, err = token.VerifyDPoPProof(proof.String(), http.MethodGet, url, "", time.Minute)
+	assert.ErrorMatch(err, ".*contains no public key.*")
+	other, err := token.GenerateKey(token.ES256)
+	assert.NoError(err)
+	proof, err = token.EncodeWithHeader(claims, other, token.Header{Algorithm: token.ES256, Type: token.DPoPType, JWK: jwk})
+	assert.NoError(err)
+	_, _, err = token.VerifyDPoPProof(proof.String(), http.MethodGet, url, "", time.Minute)
+	assert.ErrorMatch(err, ".*cannot verify the DPoP proof.*")
+	hmacKey, err := token.GenerateHMACKey(32)
+	assert.NoError(err)
+	proof, err = token.EncodeWithHeader(claims, hmacKey, token.Header{Algorithm: token.HS256, Type: token.DPoPType})
+	assert.NoError(err)
+	_, _, err = token.VerifyDPoPProof(proof.String(), http.MethodGet, url, "", time.Minute)
+	assert.ErrorMatch(err, ".*proof algorithm 'HS256' is invalid.*")
+
+	assert.Logf("testing invalid provers")
+	_, err = token.NewDPoPProver(hmacKey, token.HS256)
+	assert.ErrorMatch(err, ".*algorithm 'HS256' cannot be used for DPoP.*")
+	_, err = token.NewDPoPProver(key, token.ES512)
+	assert.ErrorMatch(err, ".*cannot use key for DPoP.*")
+}
+
+// TestDPoPAuthorize tests the authorization of requests with DPoP.
+func TestDPoPAuthorize(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := token.GenerateEd25519Key()
+	assert.NoError(err)
+	prover, err := token.NewDPoPProver(key, token.EdDSA)
+	assert.NoError(err)
+	req, err := http.NewRequest(http.MethodPut, "https://server.example.com/resource?id=1", nil)
+	assert.NoError(err)
+	err = prover.Authorize(req, "access-token")
+	assert.NoError(err)
+
+	scheme, st, err := token.RequestAuthorization(req)
+	assert.NoError(err)
+	assert.Equal(scheme, "DPoP")
+	assert.Equal(st, "access-token")
+	_, jkt, err := token.VerifyDPoPProof(req.Header.Get("DPoP"), req.Method, req.URL.String(), st, time.Minute)
+	assert.NoError(err)
+	assert.Equal(jkt, prover.Thumbprint())
+
+	claims := token.NewClaims()
+	claims.SetConfirmation(token.Confirmation{JWKThumbprint: jkt})
+	cnf, ok := claims.Confirmation()
+	assert.True(ok)
+	assert.Equal(cnf.JWKThumbprint, prover.Thumbprint())
+	assert.Equal(cnf.X509Thumbprint, "")
+}
+
+// TestDPoPProofRFC9449 tests the verification of the ES256 signed
+// example proof of RFC 9449.
+func TestDPoPProofRFC9449(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	maxAge := time.Since(time.Unix(1562262616, 0)) + time.Hour
+	proof, jkt, err := token.VerifyDPoPProof(rfc9449Proof, http.MethodPost, "https://server.example.com/token", "", maxAge)
+	assert.NoError(err)
+	assert.Equal(jkt, "0ZcOCORZNYy-DWpqq30jZyJGHTN0d2HglBV3uiguA4I")
+	jti, _ := proof.Claims().Identifier()
+	assert.Equal(jti, "-BwC3ESc6acc2lTc")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+const (
+	// rfc9449Proof is the example proof of RFC 9449 section 4.1.
+	rfc9449Proof = "eyJ0eXAiOiJkcG9wK2p3dCIsImFsZyI6IkVTMjU2IiwiandrIjp7Imt0eSI6IkVDIiwieCI6Imw4dEZyaHgtMzR0VjNoUklD" +
+		"UkRZOXpDa0RscEJoRjQyVVFVZldWQVdCRnMiLCJ5IjoiOVZFNGpmX09rX282NHpiVFRsY3VOSmFqSG10NnY5VERWclUwQ2R2R1JEQSIs" +
+		"ImNydiI6IlAtMjU2In19." +
+		"eyJqdGkiOiItQndDM0VTYzZhY2MybFRjIiwiaHRtIjoiUE9TVCIsImh0dSI6Imh0dHBzOi8vc2VydmVyLmV4YW1wbGUuY29tL3Rva2VuIiwiaWF0IjoxNTYyMjYyNjE2fQ." +
+		"2-GxA6T8lP4vfrg8v-FdWP0A0zdrj8igiMLvqRMUvwnQg4PtFLbdLXiOSsX0x7NVY-FNyJK70nfbV37xRZT3Lg"
+)
+
+// EOF
@@ -0,0 +1,143 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/json"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// HEADER
+//--------------------
+
+// registeredHeaders contains the names of the header parameters
+// with own fields in the Header.
+var registeredHeaders = map[string]bool{
+	"alg":      true,
+	"typ":      true,
+	"cty":      true,
+	"kid":      true,
+	"jku":      true,
+	"jwk":      true,
+	"x5c":      true,
+	"x5t#S256": true,
+	"crit":     true,
+}
+
+// Header contains the protected header of a token. All parameters
+// not defined as own fields are stored in Extra, there they cannot
+// override the fields. The X.509 certificate chain contains the
+// standard BASE64 encoded DER certificates.
+type Header struct {
+	Algorithm            Algorithm              `json:"alg"`
+	Type                 string                 `json:"typ,omitempty"`
+	ContentType          string                 `json:"cty,omitempty"`
+	KeyID                string                 `json:"kid,omitempty"`
+	JWKSetURL            string                 `json:"jku,omitempty"`
+	JWK                  *JWK                   `json:"jwk,omitempty"`
+	X509CertChain        []string               `json:"x5c,omitempty"`
+	X509SHA256Thumbprint string                 `json:"x5t#S256,omitempty"`
+	Critical             []string               `json:"crit,omitempty"`
+	Extra                map[string]interface{} `json:"-"`
+}
+
+// plainHeader is used to (un)marshal the fields without recursion.
+type plainHeader Header
+
+// MarshalJSON implements json.Marshaler.
+func (h Header) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(plainHeader(h))
+	if err != nil || len(h.Extra) == 0 {
+		return b, err
+	}
+	fields := map[string]interface{}{}
+	for name, value := range h.Extra {
+		fields[name] = value
+	}
+	var registered map[string]json.RawMessage
+	if err = json.Unmarshal(b, &registered); err != nil {
+		return nil, err
+	}
+	for name, value := range registered {
+		fields[name] = value
+	}
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *Header) UnmarshalJSON(b []byte) error {
+	var ph plainHeader
+	if err := json.Unmarshal(b, &ph); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	for name, raw := range fields {
+		if registeredHeaders[name] {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+		if ph.Extra == nil {
+			ph.Extra = map[string]interface{}{}
+		}
+		ph.Extra[name] = value
+	}
+	*h = Header(ph)
+	return nil
+}
+
+// checkCritical checks the critical header parameters as defined
+// in RFC 7515. All of them have to be understood by the caller,
+// must not be registered, and have to be contained in the header.
+func (h *Header) checkCritical(understood []string) error {
+	if h.Critical == nil {
+		return nil
+	}
+	if len(h.Critical) == 0 {
+		return failure.New("header parameter 'crit' is empty")
+	}
+	for _, name := range h.Critical {
+		if registeredHeaders[name] {
+			return failure.New("critical header parameter '%s' is registered", name)
+		}
+		if !containsString(understood, name) {
+			return failure.New("critical header parameter '%s' is not supported", name)
+		}
+		if _, ok := h.Extra[name]; !ok {
+			return failure.New("critical header parameter '%s' is missing", name)
+		}
+	}
+	return nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// containsString checks if the string is contained in the list.
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// EOF
@@ -0,0 +1,321 @@
+// Tideland Go Network - JSON Web Token - JSON Web Key
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// JSON WEB KEY
+//--------------------
+
+// JWK contains the public fields of a JSON Web Key (RFC 7517) for
+// the key types RSA, EC, OKP, and oct.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	K         string `json:"k,omitempty"`
+}
+
+// ReadJWK reads a JSON encoded JSON Web Key from the passed reader.
+func ReadJWK(r io.Reader) (*JWK, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, failure.New("cannot read the JWK")
+	}
+	var jwk JWK
+	if err = json.Unmarshal(data, &jwk); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal the JWK")
+	}
+	return &jwk, nil
+}
+
+// NewJWK creates the JWK for the passed key. Private keys and Signers
+// are exported with their public key only, HMAC secrets as key type
+// oct.
+func NewJWK(key Key) (*JWK, error) {
+	public, err := PublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	switch k := public.(type) {
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			KeyType: "EC",
+			Curve:   k.Curve.Params().Name,
+			X:       encodeBigInt(k.X, size),
+			Y:       encodeBigInt(k.Y, size),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType: "RSA",
+			N:       encodeBigInt(k.N, 0),
+			E:       encodeBigInt(big.NewInt(int64(k.E)), 0),
+		}, nil
+	case []byte:
+		return &JWK{
+			KeyType: "oct",
+			K:       base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	default:
+		return nil, failure.New("key type %T cannot be exported", key)
+	}
+}
+
+// Thumbprint returns the BASE64 URL encoded SHA-256 thumbprint of
+// the key as defined in RFC 7638.
+func (jwk *JWK) Thumbprint() (string, error) {
+	// The required members in lexicographic order.
+	var members interface{}
+	switch jwk.KeyType {
+	case "EC":
+		members = struct {
+			Curve   string `json:"crv"`
+			KeyType string `json:"kty"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Curve   string `json:"crv"`
+			KeyType string `json:"kty"`
+			X       string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	case "RSA":
+		members = struct {
+			E       string `json:"e"`
+			KeyType string `json:"kty"`
+			N       string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case "oct":
+		members = struct {
+			K       string `json:"k"`
+			KeyType string `json:"kty"`
+		}{jwk.K, jwk.KeyType}
+	default:
+		return "", failure.New("JWK key type '%s' is invalid", jwk.KeyType)
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", failure.Annotate(err, "cannot marshal the JWK")
+	}
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// Key returns the key described by the JWK. These are *rsa.PublicKey,
+// *ecdsa.PublicKey, ed25519.PublicKey, or []byte for the key types
+// RSA, EC, OKP, and oct.
+func (jwk *JWK) Key() (Key, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode RSA modulus")
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode RSA exponent")
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, failure.New("RSA exponent is invalid")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, failure.New("EC curve '%s' is invalid", jwk.Curve)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode EC x coordinate")
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode EC y coordinate")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, failure.New("EC point is not on curve '%s'", jwk.Curve)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Curve != "Ed25519" {
+			return nil, failure.New("OKP curve '%s' is invalid", jwk.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode OKP x coordinate")
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, failure.New("OKP key size is invalid")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		k, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot decode symmetric key")
+		}
+		return k, nil
+	default:
+		return nil, failure.New("JWK key type '%s' is invalid", jwk.KeyType)
+	}
+}
+
+// checkAlgorithm checks if the JWK may be used to verify signatures
+// created with the passed algorithm.
+func (jwk *JWK) checkAlgorithm(algorithm Algorithm) error {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return failure.New("JWK '%s' is not used for signatures", jwk.KeyID)
+	}
+	if jwk.Algorithm != "" && Algorithm(jwk.Algorithm) != algorithm {
+		return failure.New("JWK '%s' is for algorithm '%s' and not '%s'", jwk.KeyID, jwk.Algorithm, algorithm)
+	}
+	var keyType string
+	switch algorithm {
+	case ES256, ES384, ES512:
+		keyType = "EC"
+	case EdDSA:
+		keyType = "OKP"
+	case HS256, HS384, HS512:
+		keyType = "oct"
+	case PS256, PS384, PS512, RS256, RS384, RS512:
+		keyType = "RSA"
+	}
+	if jwk.KeyType != keyType {
+		return failure.New("JWK '%s' key type '%s' does not fit algorithm '%s'", jwk.KeyID, jwk.KeyType, algorithm)
+	}
+	return nil
+}
+
+//--------------------
+// JSON WEB KEY SET
+//--------------------
+
+// JWKSet contains a set of JSON Web Keys.
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// ReadJWKSet reads a JSON encoded JSON Web Key Set from the
+// passed reader.
+func ReadJWKSet(r io.Reader) (*JWKSet, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, failure.New("cannot read the JWK set")
+	}
+	var set JWKSet
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal the JWK set")
+	}
+	return &set, nil
+}
+
+// Lookup returns the key with the given key ID.
+func (set *JWKSet) Lookup(kid string) (*JWK, bool) {
+	for _, jwk := range set.Keys {
+		if jwk.KeyID == kid {
+			return jwk, true
+		}
+	}
+	return nil, false
+}
+
+// KeyFor returns the key for the verification of the passed token.
+// The key is chosen by the key ID of the token. Tokens without key
+// ID are only accepted if the set contains exactly one signing key.
+// In both cases use, algorithm, and key type of the JWK have to fit
+// the algorithm of the token.
+func (set *JWKSet) KeyFor(jwt *JWT) (Key, error) {
+	if kid := jwt.KeyID(); kid != "" {
+		jwk, ok := set.Lookup(kid)
+		if !ok {
+			return nil, failure.New("no key with ID '%s'", kid)
+		}
+		if err := jwk.checkAlgorithm(jwt.Algorithm()); err != nil {
+			return nil, err
+		}
+		return jwk.Key()
+	}
+	var candidates []*JWK
+	for _, jwk := range set.Keys {
+		if jwk.checkAlgorithm(jwt.Algorithm()) == nil {
+			candidates = append(candidates, jwk)
+		}
+	}
+	if len(candidates) != 1 {
+		return nil, failure.New("token has no key ID and key is ambiguous")
+	}
+	return candidates[0].Key()
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// decodeBigInt decodes a BASE64 URL encoded big-endian integer.
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, failure.New("value is empty")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// encodeBigInt encodes a big-endian integer BASE64 URL encoded. A
+// size greater than zero pads it with leading zeros.
+func encodeBigInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(b):], b)
+		b = padded
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// EOF
@@ -0,0 +1,202 @@
+// Tideland Go Network - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestReadJWK tests the reading of JSON Web Keys.
+func TestReadJWK(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing reading of JWKs")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.NoError(err)
+
+	// RSA.
+	jwk, err := token.ReadJWK(strings.NewReader(`{"kty":"RSA","kid":"r1","n":"` +
+		b64(rsaKey.N.Bytes()) + `","e":"` + b64(big.NewInt(int64(rsaKey.E)).Bytes()) + `"}`))
+	assert.NoError(err)
+	assert.Equal(jwk.KeyID, "r1")
+	key, err := jwk.Key()
+	assert.NoError(err)
+	assert.Equal(key, &rsaKey.PublicKey)
+
+	// EC.
+	jwk, err = token.ReadJWK(strings.NewReader(`{"kty":"EC","crv":"P-384","x":"` +
+		b64(ecKey.X.Bytes()) + `","y":"` + b64(ecKey.Y.Bytes()) + `"}`))
+	assert.NoError(err)
+	key, err = jwk.Key()
+	assert.NoError(err)
+	ecPublicKey, ok := key.(*ecdsa.PublicKey)
+	assert.True(ok)
+	assert.True(ecPublicKey.X.Cmp(ecKey.X) == 0)
+	assert.True(ecPublicKey.Y.Cmp(ecKey.Y) == 0)
+
+	// Point not on the curve.
+	jwk, err = token.ReadJWK(strings.NewReader(`{"kty":"EC","crv":"P-256","x":"` +
+		b64(ecKey.X.Bytes()) + `","y":"` + b64(ecKey.Y.Bytes()) + `"}`))
+	assert.NoError(err)
+	_, err = jwk.Key()
+	assert.ErrorMatch(err, ".*EC point is not on curve.*")
+
+	// Symmetric key.
+	jwk, err = token.ReadJWK(strings.NewReader(`{"kty":"oct","k":"` + b64([]byte("secret")) + `"}`))
+	assert.NoError(err)
+	key, err = jwk.Key()
+	assert.NoError(err)
+	assert.Equal(key, []byte("secret"))
+
+	// Invalid.
+	jwk, err = token.ReadJWK(strings.NewReader(`{"kty":"OKP","crv":"X25519"}`))
+	assert.NoError(err)
+	_, err = jwk.Key()
+	assert.ErrorMatch(err, ".*OKP curve 'X25519' is invalid.*")
+	jwk, err = token.ReadJWK(strings.NewReader(`{"kty":"XYZ"}`))
+	assert.NoError(err)
+	_, err = jwk.Key()
+	assert.ErrorMatch(err, ".*JWK key type 'XYZ' is invalid.*")
+}
+
+// TestNewJWK tests the export of keys as JSON Web Keys and
+// their thumbprints.
+func TestNewJWK(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing thumbprint of RFC 7638")
+	jwk, err := token.ReadJWK(strings.NewReader(rfc7638Key))
+	assert.NoError(err)
+	tp, err := jwk.Thumbprint()
+	assert.NoError(err)
+	assert.Equal(tp, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs")
+
+	assert.Logf("testing roundtrip of exported keys")
+	for _, algorithm := range []token.Algorithm{token.ES256, token.ES512, token.EdDSA, token.RS256} {
+		assert.Logf("algorithm %s", algorithm)
+		key, err := token.GenerateKey(algorithm)
+		assert.NoError(err)
+		jwk, err := token.NewJWK(key)
+		assert.NoError(err)
+		public, err := jwk.Key()
+		assert.NoError(err)
+		expected, err := token.PublicKey(key)
+		assert.NoError(err)
+		assert.Equal(public, expected)
+		tp, err := jwk.Thumbprint()
+		assert.NoError(err)
+		assert.Length(tp, 43)
+	}
+	_, err = token.NewJWK(42)
+	assert.ErrorMatch(err, ".*key type int is invalid.*")
+}
+
+// TestJWKSetVerify tests the verification of tokens with keys
+// of a JSON Web Key Set.
+func TestJWKSetVerify(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	assert.Logf("testing verification with JWK set")
+	set, err := token.ReadJWKSet(strings.NewReader(`{"keys":[
+		{"kty":"oct","kid":"one","k":"` + b64([]byte("secret-one")) + `"},
+		{"kty":"oct","kid":"two","k":"` + b64([]byte("secret-two")) + `"}
+	]}`))
+	assert.NoError(err)
+	assert.Length(set.Keys, 2)
+	_, ok := set.Lookup("two")
+	assert.True(ok)
+	_, ok = set.Lookup("three")
+	assert.False(ok)
+
+	claims := token.NewClaims()
+	claims.SetSubject("john")
+	jwt, err := token.Encode(claims, []byte("secret-two"), token.HS256)
+	assert.NoError(err)
+	// Without key ID the key is ambiguous.
+	_, err = token.VerifyWithKeyFunc(jwt.String(), set.KeyFor)
+	assert.ErrorMatch(err, ".*key is ambiguous.*")
+	// With only one key it's fine.
+	set.Keys = set.Keys[1:]
+	verified, err := token.VerifyWithKeyFunc(jwt.String(), set.KeyFor)
+	assert.NoError(err)
+	sub, _ := verified.Claims().Subject()
+	assert.Equal(sub, "john")
+}
+
+// TestJWKSetKeyForChecks tests the checking of use, algorithm, and
+// key type of the chosen keys.
+func TestJWKSetKeyForChecks(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	set, err := token.ReadJWKSet(strings.NewReader(`{"keys":[
+		{"kty":"oct","kid":"sig","use":"sig","alg":"HS256","k":"` + b64([]byte("secret-sig")) + `"},
+		{"kty":"oct","kid":"enc","use":"enc","k":"` + b64([]byte("secret-enc")) + `"},
+		{"kty":"oct","kid":"hs512","alg":"HS512","k":"` + b64([]byte("secret-hs512")) + `"},
+		{"kty":"RSA","kid":"rsa","e":"AQAB","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn6"}
+	]}`))
+	assert.NoError(err)
+
+	tests := []struct {
+		kid    string
+		secret string
+		err    string
+	}{
+		{"sig", "secret-sig", ""},
+		{"enc", "secret-enc", ".*not used for signatures.*"},
+		{"hs512", "secret-hs512", ".*is for algorithm 'HS512' and not 'HS256'.*"},
+		{"rsa", "secret-rsa", ".*key type 'RSA' does not fit algorithm 'HS256'.*"},
+		{"", "secret-sig", ""},
+	}
+	for _, test := range tests {
+		assert.Logf("testing key ID %q", test.kid)
+		jwt, err := token.EncodeWithHeader(token.NewClaims(), []byte(test.secret), token.Header{
+			Algorithm: token.HS256,
+			KeyID:     test.kid,
+		})
+		assert.NoError(err)
+		_, err = token.VerifyWithKeyFunc(jwt.String(), set.KeyFor)
+		if test.err == "" {
+			assert.NoError(err)
+		} else {
+			assert.ErrorMatch(err, test.err)
+		}
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// rfc7638Key is the example key of RFC 7638.
+const rfc7638Key = `{"kty":"RSA","e":"AQAB","alg":"RS256","kid":"2011-04-29",` +
+	`"n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn6` +
+	`4tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbO` +
+	`pbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}`
+
+// b64 encodes bytes in BASE64 URL encoding without padding.
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// EOF
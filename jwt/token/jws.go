@@ -0,0 +1,268 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// JSON WEB SIGNATURE
+//--------------------
+
+// JWSOptions control the serialization of JSON Web Signatures of
+// arbitrary payloads. Detached omits the payload from the result as
+// described in RFC 7515 Appendix F, the verifier has to provide it.
+// Unencoded signs the payload without BASE64 encoding as defined in
+// RFC 7797. Flattened chooses the flattened instead of the general
+// JSON serialization, it is ignored for the compact serialization.
+type JWSOptions struct {
+	Detached  bool
+	Unencoded bool
+	Flattened bool
+}
+
+// JWSSigner describes one signature of a JSON serialized JWS.
+type JWSSigner struct {
+	Key       Key
+	Algorithm Algorithm
+	KeyID     string
+}
+
+// jwsSignature is one signature of the JSON serialization.
+type jwsSignature struct {
+	Protected string                 `json:"protected,omitempty"`
+	Header    map[string]interface{} `json:"header,omitempty"`
+	Signature string                 `json:"signature"`
+}
+
+// jwsJSON is the general or flattened JSON serialization.
+type jwsJSON struct {
+	Payload    *string                `json:"payload,omitempty"`
+	Protected  string                 `json:"protected,omitempty"`
+	Header     map[string]interface{} `json:"header,omitempty"`
+	Signature  string                 `json:"signature,omitempty"`
+	Signatures []jwsSignature         `json:"signatures,omitempty"`
+}
+
+// EncodeJWS signs the payload with key and algorithm and returns
+// the compact serialization. Unencoded payloads must not contain
+// periods if they are not detached.
+func EncodeJWS(payload []byte, key Key, algorithm Algorithm, options *JWSOptions) (string, error) {
+	opts := jwsOptions(options)
+	if opts.Unencoded && !opts.Detached && strings.ContainsRune(string(payload), '.') {
+		return "", failure.New("unencoded payload must not contain periods")
+	}
+	protected, sig, err := signJWS(payload, key, algorithm, "", opts.Unencoded)
+	if err != nil {
+		return "", err
+	}
+	payloadPart := ""
+	if !opts.Detached {
+		payloadPart = encodePayload(payload, opts.Unencoded)
+	}
+	return protected + "." + payloadPart + "." + sig, nil
+}
+
+// VerifyJWS verifies the compact serialized JWS with the key and
+// returns the payload. A detached payload has to be passed, otherwise
+// it has to be nil.
+func VerifyJWS(jws string, payload []byte, key Key) ([]byte, error) {
+	parts := strings.Split(jws, ".")
+	if len(parts) != 3 {
+		return nil, failure.New("cannot verify the parts")
+	}
+	if parts[1] != "" && payload != nil {
+		return nil, failure.New("payload is not detached")
+	}
+	var payloadPart *string
+	if payload == nil {
+		payloadPart = &parts[1]
+	}
+	return verifyJWS(parts[0], payloadPart, parts[2], payload, key)
+}
+
+// EncodeJWSJSON signs the payload with all signers and returns the
+// general JSON serialization. The flattened serialization needs
+// exactly one signer.
+func EncodeJWSJSON(payload []byte, signers []JWSSigner, options *JWSOptions) ([]byte, error) {
+	opts := jwsOptions(options)
+	if len(signers) == 0 {
+		return nil, failure.New("need at least one signer")
+	}
+	if opts.Flattened && len(signers) != 1 {
+		return nil, failure.New("flattened serialization needs exactly one signer")
+	}
+	if opts.Unencoded && !utf8.Valid(payload) {
+		return nil, failure.New("unencoded payload is no valid UTF-8")
+	}
+	js := jwsJSON{}
+	if !opts.Detached {
+		payloadPart := encodePayload(payload, opts.Unencoded)
+		js.Payload = &payloadPart
+	}
+	for _, signer := range signers {
+		protected, sig, err := signJWS(payload, signer.Key, signer.Algorithm, signer.KeyID, opts.Unencoded)
+		if err != nil {
+			return nil, err
+		}
+		js.Signatures = append(js.Signatures, jwsSignature{
+			Protected: protected,
+			Signature: sig,
+		})
+	}
+	if opts.Flattened {
+		js.Protected = js.Signatures[0].Protected
+		js.Signature = js.Signatures[0].Signature
+		js.Signatures = nil
+	}
+	b, err := json.Marshal(js)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot marshal the JWS")
+	}
+	return b, nil
+}
+
+// VerifyJWSJSON verifies the general or flattened JSON serialized JWS
+// and returns the payload if one of the signatures can be verified
+// with the key. A detached payload has to be passed, otherwise it has
+// to be nil.
+func VerifyJWSJSON(jws []byte, payload []byte, key Key) ([]byte, error) {
+	var js jwsJSON
+	if err := json.Unmarshal(jws, &js); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal the JWS")
+	}
+	signatures := js.Signatures
+	if len(signatures) == 0 {
+		if js.Signature == "" {
+			return nil, failure.New("JWS contains no signature")
+		}
+		signatures = []jwsSignature{{
+			Protected: js.Protected,
+			Header:    js.Header,
+			Signature: js.Signature,
+		}}
+	}
+	if js.Payload != nil && payload != nil {
+		return nil, failure.New("payload is not detached")
+	}
+	var errs []error
+	for _, signature := range signatures {
+		content, err := verifyJWS(signature.Protected, js.Payload, signature.Signature, payload, key)
+		if err == nil {
+			return content, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, failure.Annotate(failure.Collect(errs...), "cannot verify any signature")
+}
+
+//--------------------
+// PRIVATE HELPERS
+//--------------------
+
+// jwsOptions returns the passed options or the defaults.
+func jwsOptions(options *JWSOptions) JWSOptions {
+	if options == nil {
+		return JWSOptions{}
+	}
+	return *options
+}
+
+// encodePayload returns the payload as needed in the signing
+// input and the serialization.
+func encodePayload(payload []byte, unencoded bool) string {
+	if unencoded {
+		return string(payload)
+	}
+	return base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// signJWS creates the encoded protected header and the signature
+// of the payload.
+func signJWS(payload []byte, key Key, algorithm Algorithm, keyID string, unencoded bool) (string, string, error) {
+	header := Header{
+		Algorithm: algorithm,
+		KeyID:     keyID,
+	}
+	if unencoded {
+		header.Critical = []string{"b64"}
+		header.Extra = map[string]interface{}{"b64": false}
+	}
+	protected, err := marshallAndEncode(header)
+	if err != nil {
+		return "", "", failure.Annotate(err, "cannot encode the header")
+	}
+	input := protected + "." + encodePayload(payload, unencoded)
+	sig, err := signAndEncode([]byte(input), key, algorithm)
+	if err != nil {
+		return "", "", failure.Annotate(err, "cannot encode the signature")
+	}
+	return protected, sig, nil
+}
+
+// verifyJWS verifies one signature. A nil payload part means that
+// the passed detached payload is used.
+func verifyJWS(protected string, payloadPart *string, sigPart string, payload []byte, key Key) ([]byte, error) {
+	var header Header
+	if err := decodeAndUnmarshall(protected, &header); err != nil {
+		return nil, failure.Annotate(err, "cannot verify the header")
+	}
+	unencoded, err := isUnencoded(header)
+	if err != nil {
+		return nil, err
+	}
+	content := payload
+	if payloadPart != nil {
+		if unencoded {
+			content = []byte(*payloadPart)
+		} else if content, err = base64.RawURLEncoding.DecodeString(*payloadPart); err != nil {
+			return nil, failure.Annotate(err, "part of the token contains invalid data")
+		}
+	}
+	input := protected + "." + encodePayload(content, unencoded)
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil {
+		return nil, failure.Annotate(err, "part of the token contains invalid data")
+	}
+	if err = header.Algorithm.Verify([]byte(input), sig, key); err != nil {
+		return nil, failure.Annotate(err, "cannot verify the signature")
+	}
+	return content, nil
+}
+
+// isUnencoded checks the critical header parameters and returns
+// true if the payload is not BASE64 encoded.
+func isUnencoded(header Header) (bool, error) {
+	if err := header.checkCritical([]string{"b64"}); err != nil {
+		return false, err
+	}
+	value, ok := header.Extra["b64"]
+	if !ok {
+		return false, nil
+	}
+	b64, ok := value.(bool)
+	if !ok {
+		return false, failure.New("header parameter 'b64' is invalid")
+	}
+	if !containsString(header.Critical, "b64") {
+		return false, failure.New("header parameter 'b64' has to be critical")
+	}
+	return !b64, nil
+}
+
+// EOF
@@ -0,0 +1,182 @@
+// Tideland Go Network - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestJWSCompact tests the compact serialization with the examples
+// of RFC 7797.
+func TestJWSCompact(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := base64.RawURLEncoding.DecodeString(rfc7797Key)
+	assert.Nil(err)
+	payload := []byte("$.02")
+	tests := []struct {
+		description string
+		options     *token.JWSOptions
+		jws         string
+	}{
+		{"encoded", nil,
+			"eyJhbGciOiJIUzI1NiJ9.JC4wMg.5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"},
+		{"encoded detached", &token.JWSOptions{Detached: true},
+			"eyJhbGciOiJIUzI1NiJ9..5mvfOroL-g7HyqJoozehmsaqmvTYGEq5jTI1gVvoEoQ"},
+		{"unencoded detached", &token.JWSOptions{Detached: true, Unencoded: true},
+			"eyJhbGciOiJIUzI1NiIsImI2NCI6ZmFsc2UsImNyaXQiOlsiYjY0Il19..A5dxf2s96_n5FLueVuW1Z_vh161FwXZC4YLPff6dmDY"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s compact serialization", test.description)
+		jws, err := token.EncodeJWS(payload, key, token.HS256, test.options)
+		assert.Nil(err)
+		assert.Equal(jws, test.jws)
+		var detached []byte
+		if test.options != nil && test.options.Detached {
+			detached = payload
+		}
+		verified, err := token.VerifyJWS(jws, detached, key)
+		assert.Nil(err)
+		assert.Equal(verified, payload)
+		// Manipulated payload.
+		if detached != nil {
+			_, err = token.VerifyJWS(jws, []byte("$.99"), key)
+			assert.ErrorMatch(err, ".*signature is invalid.*")
+		}
+	}
+	// Unencoded and attached payloads must not contain periods.
+	_, err = token.EncodeJWS(payload, key, token.HS256, &token.JWSOptions{Unencoded: true})
+	assert.ErrorMatch(err, ".*must not contain periods.*")
+	jws, err := token.EncodeJWS([]byte("no-periods"), key, token.HS256, &token.JWSOptions{Unencoded: true})
+	assert.Nil(err)
+	verified, err := token.VerifyJWS(jws, nil, key)
+	assert.Nil(err)
+	assert.Equal(string(verified), "no-periods")
+	// Attached payload passed again.
+	_, err = token.VerifyJWS(jws, payload, key)
+	assert.ErrorMatch(err, ".*payload is not detached.*")
+}
+
+// TestJWSCritical tests the handling of critical header parameters.
+func TestJWSCritical(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := base64.RawURLEncoding.DecodeString(rfc7797Key)
+	assert.Nil(err)
+	tests := []struct {
+		header string
+		err    string
+	}{
+		{`{"alg":"HS256","b64":false}`, ".*'b64' has to be critical.*"},
+		{`{"alg":"HS256","crit":["b64"]}`, ".*'b64' is missing.*"},
+		{`{"alg":"HS256","crit":["exp"],"exp":1}`, ".*'exp' is not supported.*"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.header)
+		protected := base64.RawURLEncoding.EncodeToString([]byte(test.header))
+		sig, err := token.HS256.Sign([]byte(protected+".payload"), key)
+		assert.Nil(err)
+		jws := protected + ".payload." + base64.RawURLEncoding.EncodeToString(sig)
+		_, err = token.VerifyJWS(jws, nil, key)
+		assert.ErrorMatch(err, test.err)
+	}
+}
+
+// TestJWSJSON tests the general and flattened JSON serialization.
+func TestJWSJSON(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	hsKey, err := base64.RawURLEncoding.DecodeString(rfc7797Key)
+	assert.Nil(err)
+	esKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	payload := []byte(`{"event":"order.created","id":42}`)
+	signers := []token.JWSSigner{
+		{Key: hsKey, Algorithm: token.HS256, KeyID: "hmac"},
+		{Key: esKey, Algorithm: token.ES256, KeyID: "ecdsa"},
+	}
+	tests := []struct {
+		description string
+		signers     []token.JWSSigner
+		options     *token.JWSOptions
+	}{
+		{"general", signers, nil},
+		{"general detached", signers, &token.JWSOptions{Detached: true}},
+		{"general unencoded", signers, &token.JWSOptions{Unencoded: true}},
+		{"flattened", signers[1:], &token.JWSOptions{Flattened: true}},
+		{"flattened unencoded detached", signers[:1], &token.JWSOptions{Flattened: true, Unencoded: true, Detached: true}},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s JSON serialization", test.description)
+		jws, err := token.EncodeJWSJSON(payload, test.signers, test.options)
+		assert.Nil(err)
+		var fields map[string]interface{}
+		err = json.Unmarshal(jws, &fields)
+		assert.Nil(err)
+		opts := token.JWSOptions{}
+		if test.options != nil {
+			opts = *test.options
+		}
+		_, hasPayload := fields["payload"]
+		_, hasSignatures := fields["signatures"]
+		_, hasSignature := fields["signature"]
+		assert.Equal(hasPayload, !opts.Detached)
+		assert.Equal(hasSignatures, !opts.Flattened)
+		assert.Equal(hasSignature, opts.Flattened)
+		if opts.Unencoded && !opts.Detached {
+			assert.Equal(fields["payload"], string(payload))
+		}
+		var detached []byte
+		if opts.Detached {
+			detached = payload
+		}
+		// Each of the keys verifies.
+		for _, signer := range test.signers {
+			publicKey, err := token.PublicKey(signer.Key)
+			assert.Nil(err)
+			verified, err := token.VerifyJWSJSON(jws, detached, publicKey)
+			assert.Nil(err)
+			assert.Equal(verified, payload)
+		}
+		// Unknown key doesn't.
+		_, err = token.VerifyJWSJSON(jws, detached, []byte(strings.Repeat("x", 32)))
+		assert.ErrorMatch(err, ".*cannot verify any signature.*")
+	}
+	// Invalid usages.
+	_, err = token.EncodeJWSJSON(payload, nil, nil)
+	assert.ErrorMatch(err, ".*need at least one signer.*")
+	_, err = token.EncodeJWSJSON(payload, signers, &token.JWSOptions{Flattened: true})
+	assert.ErrorMatch(err, ".*needs exactly one signer.*")
+	_, err = token.EncodeJWSJSON([]byte{0xff, 0xfe}, signers, &token.JWSOptions{Unencoded: true})
+	assert.ErrorMatch(err, ".*no valid UTF-8.*")
+	_, err = token.VerifyJWSJSON([]byte(`{"payload":"e30"}`), nil, hsKey)
+	assert.ErrorMatch(err, ".*contains no signature.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// rfc7797Key is the HMAC key used in the examples of RFC 7797.
+const rfc7797Key = "AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow"
+
+// EOF
@@ -13,6 +13,7 @@ package token // import "tideland.dev/go/net/jwt/token"
 
 import (
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
@@ -27,10 +28,12 @@ import (
 //--------------------
 
 // Key is the used key to sign a token. The real implementation
-// controls signing and verification.
+// controls signing and verification. Besides the private and public
+// keys it can be a Signer, a crypto.Signer, or a Verifier for keys
+// held outside of the process.
 type Key interface{}
 
-// ReadECPrivateKey reads a PEM formated ECDSA private key
+// ReadECPrivateKey reads a PEM formated SEC1 or PKCS8 ECDSA private key
 // from the passed reader.
 func ReadECPrivateKey(r io.Reader) (Key, error) {
 	pemkey, err := ioutil.ReadAll(r)
@@ -41,11 +44,19 @@ func ReadECPrivateKey(r io.Reader) (Key, error) {
 	if block, _ = pem.Decode(pemkey); block == nil {
 		return nil, failure.New("cannot decode the PEM")
 	}
-	var parsed *ecdsa.PrivateKey
-	if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
-		return nil, failure.Annotate(err, "cannot parse the ECDSA")
+	var parsed interface{}
+	parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot parse the ECDSA")
+		}
 	}
-	return parsed, nil
+	privateKey, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, failure.New("passed key is no ECDSA key")
+	}
+	return privateKey, nil
 }
 
 // ReadECPublicKey reads a PEM encoded ECDSA public key
@@ -115,11 +126,14 @@ func ReadRSAPublicKey(r io.Reader) (Key, error) {
 	var parsed interface{}
 	parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
 	if err != nil {
-		certificate, err := x509.ParseCertificate(block.Bytes)
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
 		if err != nil {
-			return nil, failure.Annotate(err, "cannot parse the RSA")
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, failure.Annotate(err, "cannot parse the RSA")
+			}
+			parsed = certificate.PublicKey
 		}
-		parsed = certificate.PublicKey
 	}
 	publicKey, ok := parsed.(*rsa.PublicKey)
 	if !ok {
@@ -128,4 +142,45 @@ func ReadRSAPublicKey(r io.Reader) (Key, error) {
 	return publicKey, nil
 }
 
+// ReadEd25519PrivateKey reads a PEM encoded PKCS8 Ed25519 private
+// key from the passed reader.
+func ReadEd25519PrivateKey(r io.Reader) (Key, error) {
+	block, err := readPEM(r)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot parse the Ed25519")
+	}
+	privateKey, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, failure.New("passed key is no Ed25519 key")
+	}
+	return privateKey, nil
+}
+
+// ReadEd25519PublicKey reads a PEM encoded PKIX Ed25519 public
+// key from the passed reader.
+func ReadEd25519PublicKey(r io.Reader) (Key, error) {
+	block, err := readPEM(r)
+	if err != nil {
+		return nil, err
+	}
+	var parsed interface{}
+	parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, failure.Annotate(err, "cannot parse the Ed25519")
+		}
+		parsed = certificate.PublicKey
+	}
+	publicKey, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, failure.New("passed key is no Ed25519 key")
+	}
+	return publicKey, nil
+}
+
 // EOF
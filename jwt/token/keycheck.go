@@ -0,0 +1,154 @@
+// Tideland Go Network - JSON Web Token - Crypto
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// KEY VALIDATION
+//--------------------
+
+// minRSABits is the minimal size of RSA keys defined by RFC 7518.
+const minRSABits = 2048
+
+// ValidateKey checks if the private or public key fulfills the
+// requirements of RFC 7518 for the algorithm. ECDSA keys have to
+// use the curve of the algorithm, RSA keys need at least 2048 bits,
+// and HMAC secrets at least the size of the hash.
+func (a Algorithm) ValidateKey(key Key) error {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return a.validateECDSA(&k.PublicKey)
+	case *ecdsa.PublicKey:
+		return a.validateECDSA(k)
+	case ed25519.PrivateKey:
+		return a.validateEdDSA(len(k) == ed25519.PrivateKeySize)
+	case ed25519.PublicKey:
+		return a.validateEdDSA(len(k) == ed25519.PublicKeySize)
+	case []byte:
+		return a.validateHMAC(k)
+	case *rsa.PrivateKey:
+		return a.validateRSA(&k.PublicKey)
+	case *rsa.PublicKey:
+		return a.validateRSA(k)
+	case string:
+		if a != NONE {
+			return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "none")
+		}
+		return nil
+	case Signer:
+		return a.ValidateKey(k.Public())
+	case crypto.Signer:
+		return a.ValidateKey(k.Public())
+	case Verifier:
+		// Key is not known, so the verifier is trusted.
+		return nil
+	default:
+		return failure.New("key type %T is invalid", key)
+	}
+}
+
+// EncodeStrict validates the key for the algorithm before it
+// encodes the token like Encode.
+func EncodeStrict(claims Claims, key Key, algorithm Algorithm) (*JWT, error) {
+	if err := algorithm.ValidateKey(key); err != nil {
+		return nil, failure.Annotate(err, "cannot encode the token")
+	}
+	return Encode(claims, key, algorithm)
+}
+
+// VerifyStrict validates the key for the algorithm of the token
+// before it verifies the token like Verify.
+func VerifyStrict(token string, key Key) (*JWT, error) {
+	return VerifyWithKeyFunc(token, StrictKeyFunc(func(jwt *JWT) (Key, error) {
+		return key, nil
+	}))
+}
+
+// StrictKeyFunc returns a key function validating the key returned
+// by kf for the algorithm of the token.
+func StrictKeyFunc(kf KeyFunc) KeyFunc {
+	return func(jwt *JWT) (Key, error) {
+		key, err := kf(jwt)
+		if err != nil {
+			return nil, err
+		}
+		if err = jwt.Algorithm().ValidateKey(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+}
+
+// validateECDSA checks if the key uses the curve of the algorithm.
+func (a Algorithm) validateECDSA(key *ecdsa.PublicKey) error {
+	var curve elliptic.Curve
+	switch a {
+	case ES256:
+		curve = elliptic.P256()
+	case ES384:
+		curve = elliptic.P384()
+	case ES512:
+		curve = elliptic.P521()
+	default:
+		return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "ECDSA")
+	}
+	if key.Curve == nil || key.Curve.Params().Name != curve.Params().Name {
+		return failure.New("ECDSA key curve does not match algorithm '%s'", a)
+	}
+	return nil
+}
+
+// validateEdDSA checks the algorithm for Ed25519 keys.
+func (a Algorithm) validateEdDSA(validSize bool) error {
+	if a != EdDSA {
+		return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "Ed25519")
+	}
+	if !validSize {
+		return failure.New("Ed25519 key size is invalid")
+	}
+	return nil
+}
+
+// validateHMAC checks if the secret is at least as long as the hash.
+func (a Algorithm) validateHMAC(key []byte) error {
+	if a != HS256 && a != HS384 && a != HS512 {
+		return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "HMAC")
+	}
+	if size := a.Hash().Size(); len(key) < size {
+		return failure.New("HMAC key for algorithm '%s' needs at least %d bytes", a, size)
+	}
+	return nil
+}
+
+// validateRSA checks if the key has at least 2048 bits.
+func (a Algorithm) validateRSA(key *rsa.PublicKey) error {
+	switch a {
+	case PS256, PS384, PS512, RS256, RS384, RS512:
+	default:
+		return failure.New("invalid combination of algorithm '%s' and key type '%s'", a, "RSA(PSS)")
+	}
+	if key.N == nil || key.N.BitLen() < minRSABits {
+		return failure.New("RSA key for algorithm '%s' needs at least %d bits", a, minRSABits)
+	}
+	return nil
+}
+
+// EOF
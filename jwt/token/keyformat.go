@@ -0,0 +1,335 @@
+// Tideland Go Network - JSON Web Token - Crypto
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"hash"
+	"io"
+	"io/ioutil"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// KEY FORMAT
+//--------------------
+
+// KeyFormat describes the DER encoding of a key.
+type KeyFormat int
+
+// Supported key formats.
+const (
+	// PKCS1 encodes RSA private or public keys.
+	PKCS1 KeyFormat = iota + 1
+	// PKCS8 encodes private keys of all types.
+	PKCS8
+	// PKIX encodes public keys of all types.
+	PKIX
+	// SEC1 encodes ECDSA private keys.
+	SEC1
+)
+
+// MarshalKey encodes the key in the passed DER format.
+func MarshalKey(key Key, format KeyFormat) ([]byte, error) {
+	der, _, err := marshalKey(key, format)
+	return der, err
+}
+
+// WriteKey writes the key PEM encoded in the passed format
+// to the writer.
+func WriteKey(w io.Writer, key Key, format KeyFormat) error {
+	der, pemType, err := marshalKey(key, format)
+	if err != nil {
+		return err
+	}
+	if err = pem.Encode(w, &pem.Block{Type: pemType, Bytes: der}); err != nil {
+		return failure.Annotate(err, "cannot write the PEM")
+	}
+	return nil
+}
+
+// marshalKey encodes the key and returns the matching PEM type.
+func marshalKey(key Key, format KeyFormat) ([]byte, string, error) {
+	switch format {
+	case PKCS1:
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return x509.MarshalPKCS1PrivateKey(k), "RSA PRIVATE KEY", nil
+		case *rsa.PublicKey:
+			return x509.MarshalPKCS1PublicKey(k), "RSA PUBLIC KEY", nil
+		}
+	case PKCS8:
+		switch key.(type) {
+		case *ecdsa.PrivateKey, ed25519.PrivateKey, *rsa.PrivateKey:
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, "", failure.Annotate(err, "cannot marshal the PKCS8")
+			}
+			return der, "PRIVATE KEY", nil
+		}
+	case PKIX:
+		switch key.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+			der, err := x509.MarshalPKIXPublicKey(key)
+			if err != nil {
+				return nil, "", failure.Annotate(err, "cannot marshal the PKIX")
+			}
+			return der, "PUBLIC KEY", nil
+		}
+	case SEC1:
+		if k, ok := key.(*ecdsa.PrivateKey); ok {
+			der, err := x509.MarshalECPrivateKey(k)
+			if err != nil {
+				return nil, "", failure.Annotate(err, "cannot marshal the SEC1")
+			}
+			return der, "EC PRIVATE KEY", nil
+		}
+	default:
+		return nil, "", failure.New("key format %d is invalid", format)
+	}
+	return nil, "", failure.New("key type %T cannot be marshalled in format %d", key, format)
+}
+
+//--------------------
+// ENCRYPTED PKCS8
+//--------------------
+
+// Numbers of PBKDF2 iterations. The first one is used when encrypting
+// private keys, the others limit the accepted numbers when reading.
+const (
+	pbkdf2Iterations    = 600000
+	minPBKDF2Iterations = 1000
+	maxPBKDF2Iterations = 10000000
+)
+
+// Object identifiers needed for encrypted PKCS8 keys (RFC 8018).
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the ASN.1 structure of an encrypted
+// PKCS8 private key.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params contains the parameters of the PBES2 scheme.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params contains the parameters of the PBKDF2 function.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// WriteEncryptedKey writes the private key as PEM encoded and with
+// the passphrase encrypted PKCS8 to the writer. It uses PBES2 with
+// PBKDF2 based on HMAC-SHA256 and AES-256-CBC.
+func WriteEncryptedKey(w io.Writer, key Key, passphrase []byte) error {
+	der, err := MarshalKey(key, PKCS8)
+	if err != nil {
+		return err
+	}
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err = rand.Read(salt); err != nil {
+		return failure.Annotate(err, "cannot create salt")
+	}
+	if _, err = rand.Read(iv); err != nil {
+		return failure.Annotate(err, "cannot create IV")
+	}
+	// Encrypt.
+	dk := pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(dk)
+	if err != nil {
+		return failure.Annotate(err, "cannot create cipher")
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	plain := append(der, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+	// Marshal parameters and info.
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF: pkix.AlgorithmIdentifier{
+			Algorithm:  oidHMACWithSHA256,
+			Parameters: asn1.NullRawValue,
+		},
+	})
+	if err != nil {
+		return failure.Annotate(err, "cannot marshal PBKDF2 parameters")
+	}
+	ivParams, err := asn1.Marshal(iv)
+	if err != nil {
+		return failure.Annotate(err, "cannot marshal IV")
+	}
+	schemeParams, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBKDF2,
+			Parameters: asn1.RawValue{FullBytes: kdfParams},
+		},
+		EncryptionScheme: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivParams},
+		},
+	})
+	if err != nil {
+		return failure.Annotate(err, "cannot marshal PBES2 parameters")
+	}
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBES2,
+			Parameters: asn1.RawValue{FullBytes: schemeParams},
+		},
+		EncryptedData: encrypted,
+	})
+	if err != nil {
+		return failure.Annotate(err, "cannot marshal encrypted key")
+	}
+	if err = pem.Encode(w, &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: info}); err != nil {
+		return failure.Annotate(err, "cannot write the PEM")
+	}
+	return nil
+}
+
+// ReadEncryptedPrivateKey reads a PEM encoded and with the passphrase
+// encrypted PKCS8 private key from the passed reader. Supported are
+// PBES2 with PBKDF2 based on HMAC-SHA1 or HMAC-SHA2 and AES-CBC.
+func ReadEncryptedPrivateKey(r io.Reader, passphrase []byte) (Key, error) {
+	block, err := readPEM(r)
+	if err != nil {
+		return nil, err
+	}
+	var info encryptedPrivateKeyInfo
+	if _, err = asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, failure.Annotate(err, "cannot parse the encrypted PKCS8")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, failure.New("encryption algorithm %v is not supported", info.Algorithm.Algorithm)
+	}
+	var scheme pbes2Params
+	if _, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &scheme); err != nil {
+		return nil, failure.Annotate(err, "cannot parse the PBES2 parameters")
+	}
+	if !scheme.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, failure.New("key derivation function %v is not supported", scheme.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err = asn1.Unmarshal(scheme.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, failure.Annotate(err, "cannot parse the PBKDF2 parameters")
+	}
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, failure.New("pseudorandom function %v is not supported", kdf.PRF.Algorithm)
+	}
+	var keyLen int
+	switch {
+	case scheme.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case scheme.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case scheme.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, failure.New("encryption scheme %v is not supported", scheme.EncryptionScheme.Algorithm)
+	}
+	if kdf.IterationCount < minPBKDF2Iterations || kdf.IterationCount > maxPBKDF2Iterations {
+		return nil, failure.New("PBKDF2 iteration count %d is out of range", kdf.IterationCount)
+	}
+	if kdf.KeyLength != 0 && kdf.KeyLength != keyLen {
+		return nil, failure.New("PBKDF2 key length %d does not match the encryption scheme", kdf.KeyLength)
+	}
+	var iv []byte
+	if _, err = asn1.Unmarshal(scheme.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, failure.Annotate(err, "cannot parse the IV")
+	}
+	if len(iv) != aes.BlockSize || len(info.EncryptedData) == 0 || len(info.EncryptedData)%aes.BlockSize != 0 {
+		return nil, failure.New("encrypted PKCS8 is invalid")
+	}
+	// Decrypt.
+	dk := pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keyLen, prf)
+	cb, err := aes.NewCipher(dk)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot create cipher")
+	}
+	plain := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(cb, iv).CryptBlocks(plain, info.EncryptedData)
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, failure.New("cannot decrypt the PKCS8, passphrase may be wrong")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(plain[:len(plain)-padding])
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot decrypt the PKCS8, passphrase may be wrong")
+	}
+	return parsed, nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// readPEM reads the first PEM block from the passed reader.
+func readPEM(r io.Reader) (*pem.Block, error) {
+	pemkey, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, failure.New("cannot read the PEM")
+	}
+	var block *pem.Block
+	if block, _ = pem.Decode(pemkey); block == nil {
+		return nil, failure.New("cannot decode the PEM")
+	}
+	return block, nil
+}
+
+// EOF
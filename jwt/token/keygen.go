@@ -0,0 +1,120 @@
+// Tideland Go Network - JSON Web Token - Crypto
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// KEY GENERATION
+//--------------------
+
+// defaultRSABits is the size of generated RSA keys.
+const defaultRSABits = 2048
+
+// GenerateKey creates a new private key suitable for the passed
+// algorithm. These are a ECDSA key on the curve of the algorithm,
+// a RSA key with 2048 bits, an Ed25519 key, or a random HMAC secret
+// with the size of the hash.
+func GenerateKey(algorithm Algorithm) (Key, error) {
+	switch algorithm {
+	case ES256:
+		return GenerateECKey(elliptic.P256())
+	case ES384:
+		return GenerateECKey(elliptic.P384())
+	case ES512:
+		return GenerateECKey(elliptic.P521())
+	case EdDSA:
+		return GenerateEd25519Key()
+	case HS256, HS384, HS512:
+		return GenerateHMACKey(algorithm.Hash().Size())
+	case PS256, PS384, PS512, RS256, RS384, RS512:
+		return GenerateRSAKey(defaultRSABits)
+	case NONE:
+		return "", nil
+	default:
+		return nil, failure.New("algorithm '%s' is invalid", algorithm)
+	}
+}
+
+// GenerateECKey creates a new ECDSA private key on the passed curve.
+func GenerateECKey(curve elliptic.Curve) (Key, error) {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot generate the ECDSA key")
+	}
+	return key, nil
+}
+
+// GenerateEd25519Key creates a new Ed25519 private key.
+func GenerateEd25519Key() (Key, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot generate the Ed25519 key")
+	}
+	return key, nil
+}
+
+// GenerateHMACKey creates a new random HMAC secret with the
+// passed size in bytes.
+func GenerateHMACKey(size int) (Key, error) {
+	if size <= 0 {
+		return nil, failure.New("HMAC key size %d is invalid", size)
+	}
+	key := make([]byte, size)
+	if _, err := rand.Read(key); err != nil {
+		return nil, failure.Annotate(err, "cannot generate the HMAC key")
+	}
+	return key, nil
+}
+
+// GenerateRSAKey creates a new RSA private key with the passed
+// number of bits.
+func GenerateRSAKey(bits int) (Key, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot generate the RSA key")
+	}
+	return key, nil
+}
+
+// PublicKey returns the public key for a private key or a Signer.
+// HMAC secrets and the key of the "none" algorithm are returned
+// unchanged as they are used for signing and verification.
+func PublicKey(key Key) (Key, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey, nil
+	case ed25519.PrivateKey:
+		return k.Public(), nil
+	case *rsa.PrivateKey:
+		return &k.PublicKey, nil
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey, []byte, string:
+		return k, nil
+	case Signer:
+		return k.Public(), nil
+	case crypto.Signer:
+		return k.Public(), nil
+	default:
+		return nil, failure.New("key type %T is invalid", key)
+	}
+}
+
+// EOF
@@ -0,0 +1,91 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// PARSING
+//--------------------
+
+// The parsing of tokens is done for each request, so it avoids
+// allocations where possible. Tokens are split without copies and
+// signatures are checked in pooled buffers.
+
+// maxPooledBufferSize is the maximum size of buffers kept in the
+// pool, so that single huge tokens do not pin memory.
+const maxPooledBufferSize = 64 * 1024
+
+// bufferPool contains the buffers for decoding the segments.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 1024)
+		return &buf
+	},
+}
+
+// splitToken splits the token into its encoded header, claims, and
+// signature segments without allocating.
+func splitToken(token string) (string, string, string, bool) {
+	first := strings.IndexByte(token, '.')
+	if first < 0 {
+		return "", "", "", false
+	}
+	second := strings.IndexByte(token[first+1:], '.')
+	if second < 0 {
+		return "", "", "", false
+	}
+	second += first + 1
+	if strings.IndexByte(token[second+1:], '.') >= 0 {
+		return "", "", "", false
+	}
+	return token[:first], token[first+1 : second], token[second+1:], true
+}
+
+// verifySignature decodes the signature segment and verifies the
+// signing of the data part (header and payload) using the passed key
+// and algorithm. Both are handled in pooled buffers unless they are
+// too large.
+func verifySignature(data, segment string, key Key, algorithm Algorithm) error {
+	// Keep data, encoded and decoded signature in one buffer.
+	size := len(data) + len(segment) + base64.RawURLEncoding.DecodedLen(len(segment))
+	var buf []byte
+	if size > maxPooledBufferSize {
+		buf = make([]byte, 0, size)
+	} else {
+		bufp := bufferPool.Get().(*[]byte)
+		defer bufferPool.Put(bufp)
+		buf = *bufp
+		if cap(buf) < size {
+			buf = make([]byte, 0, size)
+			*bufp = buf
+		}
+	}
+	buf = append(buf[:0], data...)
+	buf = append(buf, segment...)
+	dataBuf := buf[:len(data)]
+	encoded := buf[len(data):]
+	decoded := buf[len(buf):size]
+	n, err := base64.RawURLEncoding.Decode(decoded, encoded)
+	if err != nil {
+		return failure.Annotate(err, "part of the token contains invalid data")
+	}
+	return algorithm.Verify(dataBuf, Signature(decoded[:n]), key)
+}
+
+// EOF
@@ -40,6 +40,34 @@ func RequestVerify(req *http.Request, key Key) (*JWT, error) {
 	return decode(req, key)
 }
 
+// RequestToken retrieves the raw bearer token out of the
+// authorization header of a request without decoding it.
+func RequestToken(req *http.Request) (string, error) {
+	scheme, st, err := RequestAuthorization(req)
+	if err != nil {
+		return "", err
+	}
+	if scheme != "Bearer" {
+		return "", failure.New("invalid authorization header: %q", req.Header.Get("Authorization"))
+	}
+	return st, nil
+}
+
+// RequestAuthorization retrieves the scheme and the raw token out
+// of the authorization header of a request without decoding it.
+// Supported schemes are "Bearer" and "DPoP".
+func RequestAuthorization(req *http.Request) (string, string, error) {
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return "", "", failure.New("request contains no authorization header")
+	}
+	fields := strings.Fields(authorization)
+	if len(fields) != 2 || (fields[0] != "Bearer" && fields[0] != "DPoP") {
+		return "", "", failure.New("invalid authorization header: %q", authorization)
+	}
+	return fields[0], fields[1], nil
+}
+
 //--------------------
 // PRIVATE HELPERS
 //--------------------
@@ -48,21 +76,16 @@ func RequestVerify(req *http.Request, key Key) (*JWT, error) {
 // caching and verification.
 func decode(req *http.Request, key Key) (*JWT, error) {
 	// Retrieve token from header.
-	authorization := req.Header.Get("Authorization")
-	if authorization == "" {
-		return nil, failure.New("request contains no authorization header")
-	}
-	fields := strings.Fields(authorization)
-	if len(fields) != 2 || fields[0] != "Bearer" {
-		return nil, failure.New("invalid authorization header: %q", authorization)
+	st, err := RequestToken(req)
+	if err != nil {
+		return nil, err
 	}
 	// Decode or verify.
 	var jwt *JWT
-	var err error
 	if key == nil {
-		jwt, err = Decode(fields[1])
+		jwt, err = Decode(st)
 	} else {
-		jwt, err = Verify(fields[1], key)
+		jwt, err = Verify(st, key)
 	}
 	if err != nil {
 		return nil, err
@@ -0,0 +1,144 @@
+// Tideland Go Network - JSON Web Token - Crypto
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"math/big"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// SIGNER AND VERIFIER
+//--------------------
+
+// Signer signs tokens with a private key which doesn't need to be
+// in the process memory, e.g. when it is held by a KMS or a HSM. A
+// Signer can be passed as Key to Encode.
+type Signer interface {
+	// Public returns the public key matching the private key.
+	Public() crypto.PublicKey
+
+	// SignData signs the data with the algorithm.
+	SignData(data []byte, algorithm Algorithm) (Signature, error)
+}
+
+// Verifier verifies token signatures, e.g. by an external service.
+// A Verifier can be passed as Key to Verify.
+type Verifier interface {
+	// VerifyData checks the signature of the data for the algorithm.
+	// Data and signature must not be retained after returning.
+	VerifyData(data []byte, sig Signature, algorithm Algorithm) error
+}
+
+//--------------------
+// CRYPTO SIGNER
+//--------------------
+
+// ecPoint is needed to unmarshal R and S of the ASN.1 DER encoded
+// ECDSA signatures returned by a crypto.Signer or contained in tokens
+// signed by earlier versions of this package.
+type ecPoint struct {
+	R *big.Int
+	S *big.Int
+}
+
+// CryptoSigner implements Signer and Verifier based on a crypto.Signer.
+// This can be an in-process ECDSA, Ed25519, or RSA private key as well
+// as the implementation of a KMS client or a PKCS#11 module. Any
+// crypto.Signer passed as Key to Encode is used this way too.
+type CryptoSigner struct {
+	signer crypto.Signer
+}
+
+// NewCryptoSigner creates a Signer based on the crypto.Signer.
+func NewCryptoSigner(signer crypto.Signer) *CryptoSigner {
+	if signer == nil {
+		panic("need crypto signer")
+	}
+	return &CryptoSigner{
+		signer: signer,
+	}
+}
+
+// Public implements Signer.
+func (cs *CryptoSigner) Public() crypto.PublicKey {
+	return cs.signer.Public()
+}
+
+// SignData implements Signer. Only the digest of the data is passed
+// to the crypto.Signer, except for EdDSA which signs the data itself.
+func (cs *CryptoSigner) SignData(data []byte, algorithm Algorithm) (Signature, error) {
+	var keyType string
+	var opts crypto.SignerOpts
+	h := algorithm.Hash()
+	switch cs.Public().(type) {
+	case *ecdsa.PublicKey:
+		keyType = "ECDSA"
+		if algorithm.isECDSA() {
+			opts = h
+		}
+	case ed25519.PublicKey:
+		keyType = "Ed25519"
+		if algorithm == EdDSA {
+			opts = crypto.Hash(0)
+		}
+	case *rsa.PublicKey:
+		keyType = "RSA(PSS)"
+		switch algorithm {
+		case RS256, RS384, RS512:
+			opts = h
+		case PS256, PS384, PS512:
+			// Salt length as defined by RFC 7518 and expected by
+			// the most external services.
+			opts = &rsa.PSSOptions{
+				SaltLength: rsa.PSSSaltLengthEqualsHash,
+				Hash:       h,
+			}
+		}
+	default:
+		return nil, failure.New("public key type %T is invalid", cs.Public())
+	}
+	if opts == nil {
+		return nil, failure.New("invalid combination of algorithm '%s' and key type '%s'", algorithm, keyType)
+	}
+	digest := data
+	if h != 0 {
+		digest = hashSum(data, h)
+	}
+	sig, err := cs.signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot sign the data")
+	}
+	if public, ok := cs.Public().(*ecdsa.PublicKey); ok {
+		// Convert ASN.1 DER into the JWS encoding.
+		var ecp ecPoint
+		if _, err = asn1.Unmarshal(sig, &ecp); err != nil {
+			return nil, failure.Annotate(err, "cannot sign the data")
+		}
+		return encodeECDSASignature(public.Curve, ecp.R, ecp.S), nil
+	}
+	return Signature(sig), nil
+}
+
+// VerifyData implements Verifier using the public key.
+func (cs *CryptoSigner) VerifyData(data []byte, sig Signature, algorithm Algorithm) error {
+	return algorithm.Verify(data, sig, cs.Public())
+}
+
+// EOF
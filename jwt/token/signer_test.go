@@ -0,0 +1,173 @@
+// Tideland Go Network - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestCryptoSigner tests the signing with the in-process crypto signer.
+func TestCryptoSigner(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	esKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	assert.Nil(err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(err)
+	rsKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	tests := []struct {
+		algorithm token.Algorithm
+		key       crypto.Signer
+	}{
+		{token.ES384, esKey},
+		{token.EdDSA, edKey},
+		{token.RS256, rsKey},
+		{token.PS512, rsKey},
+	}
+	for _, test := range tests {
+		assert.Logf("testing crypto signer for algorithm %q", test.algorithm)
+		signer := token.NewCryptoSigner(test.key)
+		claims := token.NewClaims()
+		claims.SetSubject("john")
+		jwt, err := token.Encode(claims, signer, test.algorithm)
+		assert.Nil(err)
+		// Verify with public key and with signer.
+		_, err = token.VerifyStrict(jwt.String(), test.key.Public())
+		assert.Nil(err)
+		verified, err := token.Verify(jwt.String(), signer)
+		assert.Nil(err)
+		sub, ok := verified.Claims().Subject()
+		assert.True(ok)
+		assert.Equal(sub, "john")
+		// Any crypto.Signer is accepted directly.
+		jwt, err = token.Encode(claims, opaqueSigner{test.key}, test.algorithm)
+		assert.Nil(err)
+		_, err = token.Verify(jwt.String(), test.key.Public())
+		assert.Nil(err)
+	}
+	// Mismatching algorithms.
+	_, err = token.Encode(token.NewClaims(), token.NewCryptoSigner(esKey), token.RS256)
+	assert.ErrorMatch(err, ".* combination of algorithm .* and key type .*")
+	_, err = token.Encode(token.NewClaims(), token.NewCryptoSigner(rsKey), token.EdDSA)
+	assert.ErrorMatch(err, ".* combination of algorithm .* and key type .*")
+	err = token.ES256.ValidateKey(token.NewCryptoSigner(esKey))
+	assert.ErrorMatch(err, ".*curve does not match algorithm 'ES256'.*")
+}
+
+// TestRemoteSigner tests the signing by an external service.
+func TestRemoteSigner(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(err)
+	srv := startSigningService(assert, key)
+	defer srv.Close()
+	signer := &remoteSigner{
+		url:    srv.URL,
+		public: key.Public(),
+	}
+
+	claims := token.NewClaims()
+	claims.SetSubject("remote")
+	jwt, err := token.EncodeStrict(claims, signer, token.ES256)
+	assert.Nil(err)
+	verified, err := token.Verify(jwt.String(), key.Public())
+	assert.Nil(err)
+	sub, ok := verified.Claims().Subject()
+	assert.True(ok)
+	assert.Equal(sub, "remote")
+	// Service refuses other algorithms.
+	_, err = token.Encode(claims, signer, token.ES512)
+	assert.ErrorMatch(err, ".*signing service returned status 400.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// opaqueSigner hides the concrete type of a crypto.Signer like
+// a KMS client would do.
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+// signRequest is the request of the fake signing service.
+type signRequest struct {
+	Algorithm token.Algorithm `json:"alg"`
+	Data      []byte          `json:"data"`
+}
+
+// remoteSigner simulates a Signer delegating to an external service.
+type remoteSigner struct {
+	url    string
+	public crypto.PublicKey
+}
+
+func (rs *remoteSigner) Public() crypto.PublicKey {
+	return rs.public
+}
+
+func (rs *remoteSigner) SignData(data []byte, algorithm token.Algorithm) (token.Signature, error) {
+	body, err := json.Marshal(signRequest{algorithm, data})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.Post(rs.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("signing service returned status " + resp.Status)
+	}
+	sig, err := ioutil.ReadAll(resp.Body)
+	return token.Signature(sig), err
+}
+
+// startSigningService starts a fake KMS signing the passed data
+// with its ES256 key.
+func startSigningService(assert *asserts.Asserts, key crypto.Signer) *httptest.Server {
+	signer := token.NewCryptoSigner(key)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req signRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		assert.Nil(err)
+		if req.Algorithm != token.ES256 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sig, err := signer.SignData(req.Data, req.Algorithm)
+		assert.Nil(err)
+		_, err = w.Write(sig)
+		assert.Nil(err)
+	}))
+}
+
+// EOF
@@ -0,0 +1,201 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// ACCESS TOKEN
+//--------------------
+
+// AccessToken is a token delivered by a TokenSource for the usage
+// in outgoing requests. A zero expiry means it doesn't expire.
+type AccessToken struct {
+	Value  string
+	Expiry time.Time
+}
+
+// expiresWithin checks if the token expires within the duration.
+func (at *AccessToken) expiresWithin(d time.Duration) bool {
+	if at.Expiry.IsZero() {
+		return false
+	}
+	return time.Now().Add(d).After(at.Expiry)
+}
+
+//--------------------
+// TOKEN SOURCE
+//--------------------
+
+// TokenSource provides access tokens for outgoing requests. Each
+// call returns a new token, caching is done by the Transport.
+type TokenSource interface {
+	Token(ctx context.Context) (*AccessToken, error)
+}
+
+// staticSource always returns the same token.
+type staticSource struct {
+	token *AccessToken
+}
+
+// NewStaticSource creates a token source always returning the
+// passed token. If it is a JWT its "exp" claim is used as expiry.
+func NewStaticSource(st string) TokenSource {
+	at := &AccessToken{
+		Value: st,
+	}
+	if jwt, err := Decode(st); err == nil {
+		if exp, ok := jwt.Claims().Expiration(); ok {
+			at.Expiry = exp
+		}
+	}
+	return &staticSource{at}
+}
+
+// Token implements TokenSource.
+func (s *staticSource) Token(ctx context.Context) (*AccessToken, error) {
+	return s.token, nil
+}
+
+// signedSource creates self-signed tokens.
+type signedSource struct {
+	claims    Claims
+	key       Key
+	algorithm Algorithm
+	ttl       time.Duration
+}
+
+// NewSignedSource creates a token source signing tokens with the
+// passed key and algorithm. Each token contains a copy of the passed
+// claims plus "iat", "exp" based on the ttl, and a random "jti".
+func NewSignedSource(claims Claims, key Key, algorithm Algorithm, ttl time.Duration) TokenSource {
+	return &signedSource{
+		claims:    claims,
+		key:       key,
+		algorithm: algorithm,
+		ttl:       ttl,
+	}
+}
+
+// Token implements TokenSource.
+func (s *signedSource) Token(ctx context.Context) (*AccessToken, error) {
+	now := time.Now()
+	claims := NewClaims()
+	for k, v := range s.claims {
+		claims.Set(k, v)
+	}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return nil, failure.Annotate(err, "cannot create token identifier")
+	}
+	claims.SetIdentifier(hex.EncodeToString(jti))
+	claims.SetIssuedAt(now)
+	exp := now.Add(s.ttl)
+	claims.SetExpiration(exp)
+	jwt, err := Encode(claims, s.key, s.algorithm)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot sign token")
+	}
+	return &AccessToken{
+		Value:  jwt.String(),
+		Expiry: time.Unix(exp.Unix(), 0),
+	}, nil
+}
+
+// clientCredentialsSource retrieves tokens from an OAuth 2.0
+// authorization server.
+type clientCredentialsSource struct {
+	client       *http.Client
+	endpoint     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+}
+
+// NewClientCredentialsSource creates a token source retrieving tokens
+// from the token endpoint of an OAuth 2.0 authorization server using
+// the client credentials grant. A nil client is replaced by the default
+// HTTP client.
+func NewClientCredentialsSource(client *http.Client, endpoint, clientID, clientSecret string, scopes ...string) TokenSource {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &clientCredentialsSource{
+		client:       client,
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+	}
+}
+
+// Token implements TokenSource.
+func (s *clientCredentialsSource) Token(ctx context.Context) (*AccessToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot create token request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+	requested := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot perform token request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, failure.New("token endpoint returned status %d", resp.StatusCode)
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, failure.Annotate(err, "cannot unmarshal token response")
+	}
+	if tr.AccessToken == "" {
+		return nil, failure.New("token response contains no access token")
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "Bearer") {
+		return nil, failure.New("token type %q is not supported", tr.TokenType)
+	}
+	at := &AccessToken{
+		Value: tr.AccessToken,
+	}
+	if tr.ExpiresIn > 0 {
+		at.Expiry = requested.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	} else if jwt, err := Decode(tr.AccessToken); err == nil {
+		at.Expiry, _ = jwt.Claims().Expiration()
+	}
+	return at, nil
+}
+
+// EOF
@@ -14,7 +14,6 @@ package token // import "tideland.dev/go/net/jwt/token"
 import (
 	"encoding/base64"
 	"encoding/json"
-	"strings"
 	"time"
 
 	"tideland.dev/go/trace/failure"
@@ -24,29 +23,35 @@ import (
 // JSON Web Token
 //--------------------
 
-// jwtHeader contains the JWT header fields.
-type jwtHeader struct {
-	Algorithm string `json:"alg"`
-	Type      string `json:"typ"`
-}
+// KeyFunc returns the key for the verification of a token. It is
+// called with the decoded but not yet verified token, so that the
+// key can be chosen based on e.g. the algorithm or the key ID.
+type KeyFunc func(jwt *JWT) (Key, error)
 
 // JWT manages the parts of a JSON Web Token and the access to those.
 type JWT struct {
-	claims    Claims
-	key       Key
-	algorithm Algorithm
-	token     string
+	claims Claims
+	key    Key
+	header Header
+	token  string
 }
 
 // Encode creates a JSON Web Token for the given claims
 // based on key and algorithm.
 func Encode(claims Claims, key Key, algorithm Algorithm) (*JWT, error) {
+	return EncodeWithHeader(claims, key, Header{Algorithm: algorithm, Type: "JWT"})
+}
+
+// EncodeWithHeader creates a JSON Web Token for the given claims
+// based on key and the passed header. Its algorithm is used for
+// signing.
+func EncodeWithHeader(claims Claims, key Key, header Header) (*JWT, error) {
 	jwt := &JWT{
-		claims:    claims,
-		key:       key,
-		algorithm: algorithm,
+		claims: claims,
+		key:    key,
+		header: header,
 	}
-	headerPart, err := marshallAndEncode(jwtHeader{string(algorithm), "JWT"})
+	headerPart, err := marshallAndEncode(header)
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot encode the header")
 	}
@@ -55,7 +60,7 @@ func Encode(claims Claims, key Key, algorithm Algorithm) (*JWT, error) {
 		return nil, failure.Annotate(err, "cannot encode the claims")
 	}
 	dataParts := headerPart + "." + claimsPart
-	signaturePart, err := signAndEncode([]byte(dataParts), key, algorithm)
+	signaturePart, err := signAndEncode([]byte(dataParts), key, header.Algorithm)
 	if err != nil {
 		return nil, failure.Annotate(err, " cannot encode the signature")
 	}
@@ -65,56 +70,77 @@ func Encode(claims Claims, key Key, algorithm Algorithm) (*JWT, error) {
 
 // Decode creates a token out of a string without verification.
 func Decode(token string) (*JWT, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
+	headerPart, claimsPart, _, ok := splitToken(token)
+	if !ok {
 		return nil, failure.New("cannot decode the parts")
 	}
-	var header jwtHeader
-	err := decodeAndUnmarshall(parts[0], &header)
+	var header Header
+	err := decodeAndUnmarshall(headerPart, &header)
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot decode the header")
 	}
 	var claims Claims
-	err = decodeAndUnmarshall(parts[1], &claims)
+	err = decodeAndUnmarshall(claimsPart, &claims)
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot decode the claims")
 	}
 	return &JWT{
-		claims:    claims,
-		algorithm: Algorithm(header.Algorithm),
-		token:     token,
+		claims: claims,
+		header: header,
+		token:  token,
 	}, nil
 }
 
 // Verify creates a token out of a string and varifies it against
-// the passed key.
-func Verify(token string, key Key) (*JWT, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
+// the passed key. Tokens with critical header parameters are only
+// accepted if they are understood by the caller and named in the
+// understood arguments.
+func Verify(token string, key Key, understood ...string) (*JWT, error) {
+	headerPart, claimsPart, signaturePart, ok := splitToken(token)
+	if !ok {
 		return nil, failure.New("cannot verify the parts")
 	}
-	var header jwtHeader
-	err := decodeAndUnmarshall(parts[0], &header)
+	var header Header
+	err := decodeAndUnmarshall(headerPart, &header)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot verify the header")
+	}
+	err = header.checkCritical(understood)
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot verify the header")
 	}
-	err = decodeAndVerify(parts, key, Algorithm(header.Algorithm))
+	err = verifySignature(token[:len(headerPart)+1+len(claimsPart)], signaturePart, key, header.Algorithm)
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot verify the signature")
 	}
 	var claims Claims
-	err = decodeAndUnmarshall(parts[1], &claims)
+	err = decodeAndUnmarshall(claimsPart, &claims)
 	if err != nil {
 		return nil, failure.Annotate(err, "cannot verify the claims")
 	}
 	return &JWT{
-		claims:    claims,
-		key:       key,
-		algorithm: Algorithm(header.Algorithm),
-		token:     token,
+		claims: claims,
+		key:    key,
+		header: header,
+		token:  token,
 	}, nil
 }
 
+// VerifyWithKeyFunc creates a token out of a string and verifies it
+// against the key returned by the passed key function. Critical
+// header parameters are handled like by Verify.
+func VerifyWithKeyFunc(token string, kf KeyFunc, understood ...string) (*JWT, error) {
+	jwt, err := Decode(token)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot verify the token")
+	}
+	key, err := kf(jwt)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot retrieve the key")
+	}
+	return Verify(token, key, understood...)
+}
+
 // Claims returns the claims payload of the token.
 func (jwt *JWT) Claims() Claims {
 	return jwt.claims
@@ -130,7 +156,18 @@ func (jwt *JWT) Key() (Key, error) {
 
 // Algorithm returns the algorithm of the token after encoding, decoding, or verification.
 func (jwt *JWT) Algorithm() Algorithm {
-	return jwt.algorithm
+	return jwt.header.Algorithm
+}
+
+// KeyID returns the optional key ID of the token header.
+func (jwt *JWT) KeyID() string {
+	return jwt.header.KeyID
+}
+
+// Header returns the header of the token after encoding, decoding,
+// or verification.
+func (jwt *JWT) Header() Header {
+	return jwt.header
 }
 
 // IsValid is a convenience method checking the registered claims if the token is valid.
@@ -184,16 +221,4 @@ func signAndEncode(data []byte, key Key, algorithm Algorithm) (string, error) {
 	return encoded, nil
 }
 
-// decodeAndVerify decodes a BASE64 encoded signature and verifies
-// the correct signing of the data part (header and payload) using the
-// passed key and algorithm.
-func decodeAndVerify(parts []string, key Key, algorithm Algorithm) error {
-	data := []byte(parts[0] + "." + parts[1])
-	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
-	if err != nil {
-		return failure.Annotate(err, "part of the token contains invalid data")
-	}
-	return algorithm.Verify(data, sig, key)
-}
-
 // EOF
@@ -12,6 +12,9 @@ package token_test
 //--------------------
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -62,6 +65,137 @@ func TestDecode(t *testing.T) {
 	assert.Equal(exp, time.Time{})
 }
 
+// TestEncodeWithHeader tests the encoding with extended headers.
+func TestEncodeWithHeader(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte(strings.Repeat("k", 64))
+	header := token.Header{
+		Algorithm:            token.HS512,
+		Type:                 "JWT",
+		ContentType:          "application/example",
+		KeyID:                "key-1",
+		JWKSetURL:            "https://example.com/jwks.json",
+		X509CertChain:        []string{"MIIB"},
+		X509SHA256Thumbprint: "thumbprint",
+		Extra: map[string]interface{}{
+			"tenant": "acme",
+			"alg":    "none",
+		},
+	}
+	jwt, err := token.EncodeWithHeader(token.NewClaims(), key, header)
+	assert.Nil(err)
+	// Check raw header.
+	parts := strings.Split(jwt.String(), ".")
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	assert.Nil(err)
+	var fields map[string]interface{}
+	err = json.Unmarshal(raw, &fields)
+	assert.Nil(err)
+	assert.Length(fields, 8)
+	assert.Equal(fields["alg"], "HS512")
+	assert.Equal(fields["x5t#S256"], "thumbprint")
+	assert.Equal(fields["tenant"], "acme")
+	// Verify and check parsed header.
+	jwt, err = token.Verify(jwt.String(), key)
+	assert.Nil(err)
+	parsed := jwt.Header()
+	assert.Equal(parsed.Algorithm, token.HS512)
+	assert.Equal(parsed.ContentType, "application/example")
+	assert.Equal(parsed.KeyID, "key-1")
+	assert.Equal(jwt.KeyID(), "key-1")
+	assert.Equal(parsed.JWKSetURL, "https://example.com/jwks.json")
+	assert.Equal(parsed.X509CertChain, []string{"MIIB"})
+	assert.Equal(parsed.X509SHA256Thumbprint, "thumbprint")
+	assert.Equal(parsed.Extra, map[string]interface{}{"tenant": "acme"})
+	// Default header stays unchanged.
+	jwt, err = token.Encode(token.NewClaims(), key, token.HS512)
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(jwt.String(), "eyJhbGciOiJIUzUxMiIsInR5cCI6IkpXVCJ9."))
+}
+
+// TestVerifyCritical tests the handling of critical header parameters.
+func TestVerifyCritical(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte(strings.Repeat("k", 64))
+	tests := []struct {
+		critical   []string
+		extra      map[string]interface{}
+		understood []string
+		err        string
+	}{
+		{nil, nil, nil, ""},
+		{[]string{"exp"}, map[string]interface{}{"exp": 1}, []string{"exp"}, ""},
+		{[]string{"exp"}, map[string]interface{}{"exp": 1}, nil, ".*'exp' is not supported.*"},
+		{[]string{"exp"}, nil, []string{"exp"}, ".*'exp' is missing.*"},
+		{[]string{"kid"}, nil, []string{"kid"}, ".*'kid' is registered.*"},
+		{[]string{}, nil, nil, ".*'crit' is empty.*"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %v", i, test.critical)
+		header := token.Header{
+			Algorithm: token.HS512,
+			Critical:  test.critical,
+			Extra:     test.extra,
+		}
+		if test.critical != nil && len(test.critical) == 0 {
+			// Empty lists are omitted when marshalling.
+			header.Extra = map[string]interface{}{"crit": []string{}}
+		}
+		jwt, err := token.EncodeWithHeader(token.NewClaims(), key, header)
+		assert.Nil(err)
+		// Decoding ignores critical parameters.
+		_, err = token.Decode(jwt.String())
+		assert.Nil(err)
+		_, err = token.Verify(jwt.String(), key, test.understood...)
+		if test.err == "" {
+			assert.Nil(err)
+		} else {
+			assert.ErrorMatch(err, test.err)
+		}
+	}
+}
+
+// TestVerifyMalformed tests the rejection of malformed tokens and
+// the handling of special claims.
+func TestVerifyMalformed(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte(strings.Repeat("k", 64))
+	sign := func(header, claims string) string {
+		data := b64([]byte(header)) + "." + b64([]byte(claims))
+		sig, err := token.HS512.Sign([]byte(data), key)
+		assert.Nil(err)
+		return data + "." + b64(sig)
+	}
+	valid := sign(`{"alg":"HS512"}`, `{"sub":"john"}`)
+	tests := []struct {
+		description string
+		token       string
+		err         string
+	}{
+		{"too few parts", "a.b", ".*cannot verify the parts.*"},
+		{"too many parts", valid + ".d", ".*cannot verify the parts.*"},
+		{"invalid header", "!." + valid[strings.Index(valid, ".")+1:], ".*cannot verify the header.*"},
+		{"invalid signature encoding", valid + "!", ".*cannot verify the signature.*invalid data.*"},
+		{"invalid signature", valid[:len(valid)-4] + "AAAA", ".*cannot verify the signature.*"},
+		{"claims array", sign(`{"alg":"HS512"}`, `["john"]`), ".*cannot verify the claims.*"},
+		{"claims string", sign(`{"alg":"HS512"}`, `"john"`), ".*cannot verify the claims.*"},
+		{"invalid claims", sign(`{"alg":"HS512"}`, `{"sub":`), ".*cannot verify the claims.*"},
+		{"null claims", sign(`{"alg":"HS512"}`, `null`), ""},
+		{"spaced claims", sign(`{"alg":"HS512"}`, ` { "sub" : "john" } `), ""},
+		{"large claims", sign(`{"alg":"HS512"}`, `{"sub":"`+strings.Repeat("j", 100000)+`"}`), ""},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.description)
+		jwt, err := token.Verify(test.token, key)
+		if test.err != "" {
+			assert.ErrorMatch(err, test.err)
+			continue
+		}
+		assert.Nil(err)
+		assert.True(jwt.IsValid(time.Minute))
+	}
+}
+
 // TestIsValid checks the time validation of a token.
 func TestIsValid(t *testing.T) {
 	assert := asserts.NewTesting(t, asserts.FailStop)
@@ -109,4 +243,105 @@ func TestIsValid(t *testing.T) {
 	assert.False(ok)
 }
 
+//--------------------
+// BENCHMARKS
+//--------------------
+
+// BenchmarkVerify benchmarks the verification of tokens for
+// each algorithm.
+func BenchmarkVerify(b *testing.B) {
+	for _, algorithm := range benchmarkAlgorithms {
+		key, err := token.GenerateKey(algorithm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		public, err := token.PublicKey(key)
+		if err != nil {
+			b.Fatal(err)
+		}
+		jwt, err := token.Encode(benchmarkClaims(), key, algorithm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		st := jwt.String()
+		b.Run(string(algorithm), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				verified, err := token.Verify(st, public)
+				if err != nil || !verified.IsValid(time.Minute) {
+					b.Fatal("cannot verify token", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDecode benchmarks the decoding of tokens.
+func BenchmarkDecode(b *testing.B) {
+	jwt, err := token.Encode(benchmarkClaims(), []byte(benchmarkSecret), token.HS256)
+	if err != nil {
+		b.Fatal(err)
+	}
+	st := jwt.String()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		decoded, err := token.Decode(st)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, ok := decoded.Claims().Subject(); !ok {
+			b.Fatal("missing subject")
+		}
+	}
+}
+
+// BenchmarkEncode benchmarks the encoding of tokens for
+// each algorithm.
+func BenchmarkEncode(b *testing.B) {
+	for _, algorithm := range benchmarkAlgorithms {
+		key, err := token.GenerateKey(algorithm)
+		if err != nil {
+			b.Fatal(err)
+		}
+		claims := benchmarkClaims()
+		b.Run(string(algorithm), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := token.Encode(claims, key, algorithm); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// benchmarkSecret is the HMAC secret for benchmarks.
+const benchmarkSecret = "0123456789abcdef0123456789abcdef"
+
+// benchmarkAlgorithms contains all algorithms benchmarked.
+var benchmarkAlgorithms = []token.Algorithm{
+	token.ES256, token.ES384, token.ES512,
+	token.EdDSA,
+	token.HS256, token.HS384, token.HS512,
+	token.PS256, token.PS384, token.PS512,
+	token.RS256, token.RS384, token.RS512,
+}
+
+// benchmarkClaims returns typical claims of an access token.
+func benchmarkClaims() token.Claims {
+	claims := token.NewClaims()
+	claims.SetIssuer("https://issuer.example.com")
+	claims.SetSubject(subClaim)
+	claims.SetAudience("api")
+	claims.SetIssuedAt(time.Now())
+	claims.SetExpiration(time.Now().Add(time.Hour))
+	claims.Set("name", nameClaim)
+	claims.Set("scope", "read write")
+	return claims
+}
+
 // EOF
@@ -0,0 +1,143 @@
+// Tideland Go Network - JSON Web Token
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token // import "tideland.dev/go/net/jwt/token"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+)
+
+//--------------------
+// TRANSPORT
+//--------------------
+
+// defaultRefreshAhead is the default duration before the expiry
+// of a token when it will be refreshed.
+const defaultRefreshAhead = 30 * time.Second
+
+// Transport is a http.RoundTripper adding a bearer token of a
+// TokenSource to each request. The token is reused until it
+// expires within the refresh ahead duration. If a request is
+// answered with status unauthorized the token is refreshed and
+// the request is retried once. The transport is safe for
+// concurrent use.
+type Transport struct {
+	base         http.RoundTripper
+	source       TokenSource
+	refreshAhead time.Duration
+	mu           sync.Mutex
+	token        *AccessToken
+}
+
+// NewTransport creates a transport using the token source. A nil
+// base is replaced by the default transport, a zero refresh ahead
+// duration by 30 seconds.
+func NewTransport(base http.RoundTripper, source TokenSource, refreshAhead time.Duration) *Transport {
+	if source == nil {
+		panic("need token source")
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if refreshAhead == 0 {
+		refreshAhead = defaultRefreshAhead
+	}
+	return &Transport{
+		base:         base,
+		source:       source,
+		refreshAhead: refreshAhead,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	at, err := t.current(req.Context(), nil)
+	if err != nil {
+		closeBody(req)
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(authorized(req, at))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// Retry once with a fresh token if the body can be replayed.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	retry := authorized(req, nil)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	at, err = t.current(req.Context(), at)
+	if err != nil {
+		return resp, nil
+	}
+	drainBody(resp.Body)
+	retry.Header.Set("Authorization", "Bearer "+at.Value)
+	return t.base.RoundTrip(retry)
+}
+
+// current returns the current token. It is refreshed if needed or if
+// it is the rejected token.
+func (t *Transport) current(ctx context.Context, rejected *AccessToken) (*AccessToken, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != nil && t.token != rejected && !t.token.expiresWithin(t.refreshAhead) {
+		return t.token, nil
+	}
+	at, err := t.source.Token(ctx)
+	if err != nil {
+		return nil, failure.Annotate(err, "cannot retrieve token")
+	}
+	t.token = at
+	return at, nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// authorized returns a copy of the request with the authorization
+// header set, the original request must not be modified.
+func authorized(req *http.Request, at *AccessToken) *http.Request {
+	clone := req.Clone(req.Context())
+	if at != nil {
+		clone.Header.Set("Authorization", "Bearer "+at.Value)
+	}
+	return clone
+}
+
+// closeBody closes the body of a request if it has one.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// drainBody reads and closes a response body so that the
+// connection can be reused.
+func drainBody(body io.ReadCloser) {
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(body, 4096))
+	body.Close()
+}
+
+// EOF
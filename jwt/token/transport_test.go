@@ -0,0 +1,228 @@
+// Tideland Go Network - JSON Web Token - Unit Tests
+//
+// Copyright (C) 2016-2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package token_test
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestTransportRefresh tests the refreshing of tokens ahead
+// of their expiration.
+func TestTransportRefresh(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte("secret")
+	srv := startProtectedServer(assert, key, nil)
+	defer srv.Close()
+	source := &countingSource{
+		source: token.NewSignedSource(token.Claims{"sub": "service"}, key, token.HS512, 3*time.Second),
+	}
+	client := &http.Client{
+		Transport: token.NewTransport(nil, source, time.Second),
+	}
+
+	// First requests reuse the token.
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		assert.NoError(err)
+		resp.Body.Close()
+		assert.Equal(resp.StatusCode, http.StatusOK)
+	}
+	assert.Equal(source.count(), 1)
+	// Now wait until the token is within refresh ahead duration.
+	time.Sleep(2100 * time.Millisecond)
+	resp, err := client.Get(srv.URL)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(resp.StatusCode, http.StatusOK)
+	assert.Equal(source.count(), 2)
+}
+
+// TestTransportRetry tests the retry with a fresh token after
+// the rejection of a request.
+func TestTransportRetry(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte("secret")
+	var revoked sync.Map
+	srv := startProtectedServer(assert, key, &revoked)
+	defer srv.Close()
+	source := &countingSource{
+		source: token.NewSignedSource(token.Claims{"sub": "service"}, key, token.HS512, time.Hour),
+	}
+	client := &http.Client{
+		Transport: token.NewTransport(nil, source, 0),
+	}
+
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(resp.StatusCode, http.StatusOK)
+	assert.Equal(source.count(), 1)
+	// Revoke the current token, next request is retried with a new one.
+	source.mu.Lock()
+	revoked.Store(source.last, true)
+	source.mu.Unlock()
+	resp, err = client.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(resp.StatusCode, http.StatusOK)
+	assert.Equal(source.count(), 2)
+	// Static tokens stay rejected, no endless retries.
+	static := token.NewStaticSource("invalid.token.value")
+	client = &http.Client{
+		Transport: token.NewTransport(nil, static, 0),
+	}
+	resp, err = client.Get(srv.URL)
+	assert.NoError(err)
+	resp.Body.Close()
+	assert.Equal(resp.StatusCode, http.StatusUnauthorized)
+}
+
+// TestTransportConcurrency tests the concurrent usage of the transport.
+func TestTransportConcurrency(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	key := []byte("secret")
+	srv := startProtectedServer(assert, key, nil)
+	defer srv.Close()
+	source := &countingSource{
+		source: token.NewSignedSource(token.Claims{"sub": "service"}, key, token.HS512, time.Hour),
+	}
+	client := &http.Client{
+		Transport: token.NewTransport(nil, source, 0),
+	}
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < 25; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil || resp.StatusCode != http.StatusOK {
+				atomic.AddInt32(&failures, 1)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(atomic.LoadInt32(&failures), int32(0))
+	assert.Equal(source.count(), 1)
+}
+
+// TestClientCredentialsSource tests the retrieval of tokens with
+// the OAuth 2.0 client credentials grant.
+func TestClientCredentialsSource(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(r.FormValue("grant_type"), "client_credentials")
+		assert.Equal(r.FormValue("scope"), "read write")
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "opaque-access-token",
+			"token_type":   "bearer",
+			"expires_in":   3600,
+		})
+		assert.NoError(err)
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	source := token.NewClientCredentialsSource(nil, srv.URL, "client", "secret", "read", "write")
+	at, err := source.Token(ctx)
+	assert.NoError(err)
+	assert.Equal(at.Value, "opaque-access-token")
+	assert.True(at.Expiry.After(time.Now().Add(59 * time.Minute)))
+
+	source = token.NewClientCredentialsSource(nil, srv.URL, "client", "wrong")
+	_, err = source.Token(ctx)
+	assert.ErrorMatch(err, ".*returned status 401.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// countingSource counts the retrieved tokens.
+type countingSource struct {
+	mu     sync.Mutex
+	source token.TokenSource
+	n      int
+	last   string
+}
+
+func (s *countingSource) Token(ctx context.Context) (*token.AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	at, err := s.source.Token(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.n++
+	s.last = at.Value
+	return at, nil
+}
+
+func (s *countingSource) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.n
+}
+
+// startProtectedServer starts a server only accepting requests with
+// valid and not revoked tokens. It also checks that the body arrives.
+func startProtectedServer(assert *asserts.Asserts, key token.Key, revoked *sync.Map) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwt, err := token.RequestVerify(r, key)
+		if err == nil && !jwt.IsValid(0) {
+			err = errors.New("token is expired")
+		}
+		if err == nil && revoked != nil {
+			if _, ok := revoked.Load(jwt.String()); ok {
+				err = errors.New("token is revoked")
+			}
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == http.MethodPost {
+			body, err := ioutil.ReadAll(r.Body)
+			assert.NoError(err)
+			assert.Equal(string(body), "payload")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// EOF
@@ -0,0 +1,247 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// ACCESS RECORD
+//--------------------
+
+// AccessRecord describes a request served by the AccessLogHandler.
+// Subject is the subject of the JSON Web Token if a JWTHandler has
+// authenticated the request, Duration is measured until the wrapped
+// handler returned.
+type AccessRecord struct {
+	Time       time.Time     `json:"time"`
+	RemoteHost string        `json:"remoteHost"`
+	Subject    string        `json:"subject,omitempty"`
+	Method     string        `json:"method"`
+	URI        string        `json:"uri"`
+	Proto      string        `json:"proto"`
+	StatusCode int           `json:"statusCode"`
+	Size       int64         `json:"size"`
+	Duration   time.Duration `json:"duration"`
+	Referer    string        `json:"referer,omitempty"`
+	UserAgent  string        `json:"userAgent,omitempty"`
+}
+
+// AccessLogger receives the records of the AccessLogHandler. It is
+// called concurrently.
+type AccessLogger func(record *AccessRecord)
+
+// CommonLogger writes the records in the Common Log Format to the
+// writer.
+func CommonLogger(w io.Writer) AccessLogger {
+	return lineLogger(w, func(record *AccessRecord) []byte {
+		return appendCommon(nil, record)
+	})
+}
+
+// CombinedLogger writes the records in the Combined Log Format to
+// the writer.
+func CombinedLogger(w io.Writer) AccessLogger {
+	return lineLogger(w, func(record *AccessRecord) []byte {
+		line := appendCommon(nil, record)
+		line = append(line, ' ')
+		line = appendQuoted(line, record.Referer)
+		line = append(line, ' ')
+		return appendQuoted(line, record.UserAgent)
+	})
+}
+
+// JSONLogger writes the records as JSON lines to the writer. The
+// duration is written in nanoseconds.
+func JSONLogger(w io.Writer) AccessLogger {
+	return lineLogger(w, func(record *AccessRecord) []byte {
+		line, _ := json.Marshal(record)
+		return line
+	})
+}
+
+//--------------------
+// ACCESS LOG HANDLER
+//--------------------
+
+// AccessLogHandler passes a record of each request to an access
+// logger after the wrapped handler returned. Without access logger
+// the records are logged in the Common Log Format with info level.
+// Panics of the wrapped handler are logged with status code 500 if
+// the response hasn't been started and passed on afterwards, e.g. to
+// an outer RecoveryHandler.
+type AccessLogHandler struct {
+	handler http.Handler
+	log     AccessLogger
+}
+
+// NewAccessLogHandler creates a handler logging the accesses to the
+// given handler.
+func NewAccessLogHandler(handler http.Handler, log AccessLogger) *AccessLogHandler {
+	if handler == nil {
+		panic("need handler")
+	}
+	if log == nil {
+		log = func(record *AccessRecord) {
+			logger.Infof("%s", appendCommon(nil, record))
+		}
+	}
+	return &AccessLogHandler{
+		handler: handler,
+		log:     log,
+	}
+}
+
+// AccessLogMiddleware returns a middleware wrapping handlers with an
+// AccessLogHandler using the given access logger.
+func AccessLogMiddleware(log AccessLogger) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewAccessLogHandler(handler, log)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (ah *AccessLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	record := &AccessRecord{
+		Time:       time.Now(),
+		RemoteHost: r.RemoteAddr,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		record.RemoteHost = host
+	}
+	if record.URI == "" {
+		record.URI = r.URL.RequestURI()
+	}
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		record.Subject, _ = claims.Subject()
+	}
+	sw := &statusResponseWriter{
+		ResponseWriter: w,
+	}
+	// Let an inner JWTHandler set the subject.
+	r = r.WithContext(context.WithValue(r.Context(), accessRecordKey, record))
+	defer func() {
+		v := recover()
+		record.Duration = time.Since(record.Time)
+		record.StatusCode = sw.statusCode
+		switch {
+		case record.StatusCode != 0:
+		case v != nil:
+			// Answered by an outer RecoveryHandler or net/http.
+			record.StatusCode = http.StatusInternalServerError
+		default:
+			record.StatusCode = http.StatusOK
+		}
+		record.Size = sw.written
+		ah.log(record)
+		if v != nil {
+			panic(v)
+		}
+	}()
+	ah.handler.ServeHTTP(sw, r)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// setAccessSubject sets the subject of the access record of the
+// request, if any.
+func setAccessSubject(r *http.Request, subject string) {
+	if record, ok := r.Context().Value(accessRecordKey).(*AccessRecord); ok {
+		record.Subject = subject
+	}
+}
+
+// lineLogger creates an access logger writing the formatted records
+// as lines to the writer.
+func lineLogger(w io.Writer, format func(record *AccessRecord) []byte) AccessLogger {
+	if w == nil {
+		panic("need writer")
+	}
+	var mu sync.Mutex
+	return func(record *AccessRecord) {
+		line := append(format(record), '\n')
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := w.Write(line); err != nil {
+			logger.Errorf("access log handler: %v", err)
+		}
+	}
+}
+
+// appendCommon appends the record in the Common Log Format.
+func appendCommon(line []byte, record *AccessRecord) []byte {
+	line = appendField(line, record.RemoteHost)
+	line = append(line, " - "...)
+	line = appendField(line, record.Subject)
+	line = append(line, " ["...)
+	line = record.Time.AppendFormat(line, "02/Jan/2006:15:04:05 -0700")
+	line = append(line, "] "...)
+	line = appendQuoted(line, record.Method+" "+record.URI+" "+record.Proto)
+	line = append(line, ' ')
+	line = strconv.AppendInt(line, int64(record.StatusCode), 10)
+	line = append(line, ' ')
+	if record.Size == 0 {
+		return append(line, '-')
+	}
+	return strconv.AppendInt(line, record.Size, 10)
+}
+
+// appendField appends the value without spaces or a dash if it
+// is empty.
+func appendField(line []byte, value string) []byte {
+	if value == "" {
+		return append(line, '-')
+	}
+	return append(line, strings.Replace(value, " ", "_", -1)...)
+}
+
+// appendQuoted appends the value in quotes with escaped quotes,
+// backslashes, and control characters.
+func appendQuoted(line []byte, value string) []byte {
+	if value == "" {
+		return append(line, `"-"`...)
+	}
+	line = append(line, '"')
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			line = append(line, '\\', c)
+		case c < 0x20 || c == 0x7f:
+			line = append(line, `\x`...)
+			line = append(line, "0123456789abcdef"[c>>4], "0123456789abcdef"[c&0xf])
+		default:
+			line = append(line, c)
+		}
+	}
+	return append(line, '"')
+}
+
+// EOF
@@ -0,0 +1,217 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidAccessLogHandler tests the panics for invalid values
+// passed to the AccessLogHandler and the loggers.
+func TestInvalidAccessLogHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	assert.Panics(func() {
+		web.NewAccessLogHandler(nil, nil)
+	}, "need handler")
+	assert.Panics(func() {
+		web.CommonLogger(nil)
+	}, "need writer")
+}
+
+// TestAccessLogFormats tests the logging in the different formats.
+func TestAccessLogFormats(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	var common, combined, jsonLines bytes.Buffer
+	chain := web.NewChain(
+		web.AccessLogMiddleware(web.CommonLogger(&common)),
+		web.AccessLogMiddleware(web.CombinedLogger(&combined)),
+		web.AccessLogMiddleware(web.JSONLogger(&jsonLines)),
+	)
+
+	wa.Handle("/log/", chain.ThenFunc(makeMethodEcho(assert)))
+	wa.Handle("/empty/", chain.ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	wreq := wa.CreateRequest(http.MethodGet, "/log/orders?id=1")
+	wreq.Header().Set("Referer", "https://example.com/")
+	wreq.Header().Set("User-Agent", `Tester "1.0"`)
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusOK)
+	wresp = wa.CreateRequest(http.MethodDelete, "/empty/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusNoContent)
+
+	date := `\[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\]`
+	lines := strings.Split(strings.TrimSpace(common.String()), "\n")
+	assert.Length(lines, 2)
+	assert.Match(lines[0], `127\.0\.0\.1 - - `+date+` "GET /log/orders\?id=1 HTTP/1\.1" 200 12`)
+	assert.Match(lines[1], `127\.0\.0\.1 - - `+date+` "DELETE /empty/ HTTP/1\.1" 204 -`)
+
+	lines = strings.Split(strings.TrimSpace(combined.String()), "\n")
+	assert.Length(lines, 2)
+	assert.Match(lines[0], `127\.0\.0\.1 - - `+date+` "GET /log/orders\?id=1 HTTP/1\.1" 200 12 "https://example\.com/" "Tester \\"1\.0\\""`)
+	assert.Match(lines[1], `.* 204 - "-" "Go-http-client/1\.1"`)
+
+	lines = strings.Split(strings.TrimSpace(jsonLines.String()), "\n")
+	assert.Length(lines, 2)
+	var record web.AccessRecord
+	err := json.Unmarshal([]byte(lines[0]), &record)
+	assert.NoError(err)
+	assert.Equal(record.RemoteHost, "127.0.0.1")
+	assert.Equal(record.Method, http.MethodGet)
+	assert.Equal(record.URI, "/log/orders?id=1")
+	assert.Equal(record.Proto, "HTTP/1.1")
+	assert.Equal(record.StatusCode, http.StatusOK)
+	assert.Equal(record.Size, int64(12))
+	assert.Equal(record.Referer, "https://example.com/")
+	assert.Equal(record.UserAgent, `Tester "1.0"`)
+	assert.True(record.Duration > 0)
+	assert.True(time.Since(record.Time) < time.Minute)
+}
+
+// TestAccessLogSubject tests the logging of the subject of
+// authenticated requests.
+func TestAccessLogSubject(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	var mu sync.Mutex
+	var subjects []string
+	log := func(record *web.AccessRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		subjects = append(subjects, record.Subject)
+	}
+	key := []byte(strings.Repeat("secret", 11))
+	jwtConfig := &web.JWTHandlerConfig{
+		Key:      key,
+		Optional: true,
+	}
+
+	wa.Handle("/inner/", web.NewChain(
+		web.AccessLogMiddleware(log),
+		web.JWTMiddleware(jwtConfig),
+	).ThenFunc(makeMethodEcho(assert)))
+	wa.Handle("/outer/", web.NewChain(
+		web.JWTMiddleware(jwtConfig),
+		web.AccessLogMiddleware(log),
+	).ThenFunc(makeMethodEcho(assert)))
+	wa.Handle("/default/", web.NewChain(
+		web.AccessLogMiddleware(nil),
+		web.JWTMiddleware(jwtConfig),
+	).ThenFunc(makeMethodEcho(assert)))
+
+	claims := token.NewClaims()
+	claims.SetSubject("john doe")
+	jwt, err := token.Encode(claims, key, token.HS512)
+	assert.NoError(err)
+
+	for _, path := range []string{"/inner/", "/outer/", "/default/"} {
+		assert.Logf("testing %s", path)
+		wreq := wa.CreateRequest(http.MethodGet, path)
+		wreq.Header().Set("Authorization", "Bearer "+jwt.String())
+		wreq.Do().AssertStatusCodeEquals(http.StatusOK)
+		wa.CreateRequest(http.MethodGet, path).Do().AssertStatusCodeEquals(http.StatusOK)
+	}
+	assert.Equal(subjects, []string{"john doe", "", "john doe", ""})
+	entries := tw.Entries()
+	assert.Length(entries, 2)
+	assert.Match(entries[0], `.*\[INFO\] 127\.0\.0\.1 - john_doe \[.*\] "GET /default/ HTTP/1\.1" 200 12`)
+	assert.Match(entries[1], `.*\[INFO\] 127\.0\.0\.1 - - \[.*\] "GET /default/ HTTP/1\.1" 200 12`)
+}
+
+// TestAccessLogPanic tests the logging of requests whose handler
+// panics, independent of the order of access log and recovery. Early
+// hints do not start the response.
+func TestAccessLogPanic(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	var mu sync.Mutex
+	var records []*web.AccessRecord
+	log := func(record *web.AccessRecord) {
+		mu.Lock()
+		defer mu.Unlock()
+		records = append(records, record)
+	}
+	panicking := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("early") != "" {
+			w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+			w.WriteHeader(http.StatusEarlyHints)
+		}
+		if r.URL.Query().Get("started") != "" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		panic("ouch")
+	}
+
+	wa.Handle("/inner/", web.NewChain(
+		web.RecoveryMiddleware(nil),
+		web.AccessLogMiddleware(log),
+	).ThenFunc(panicking))
+	wa.Handle("/outer/", web.NewChain(
+		web.AccessLogMiddleware(log),
+		web.RecoveryMiddleware(nil),
+	).ThenFunc(panicking))
+
+	tests := []struct {
+		path       string
+		statusCode int
+		written    bool
+	}{
+		{"/inner/", http.StatusInternalServerError, false},
+		{"/outer/", http.StatusInternalServerError, true},
+		{"/inner/?started=1", http.StatusAccepted, false},
+		{"/outer/?started=1", http.StatusAccepted, false},
+		{"/inner/?early=1", http.StatusInternalServerError, false},
+		{"/outer/?early=1", http.StatusInternalServerError, true},
+	}
+	for i, test := range tests {
+		assert.Logf("testing %s", test.path)
+		wa.CreateRequest(http.MethodGet, test.path).Do().AssertStatusCodeEquals(test.statusCode)
+		mu.Lock()
+		assert.Length(records, i+1)
+		record := records[i]
+		mu.Unlock()
+		assert.Equal(record.StatusCode, test.statusCode)
+		assert.Equal(record.Size > 0, test.written)
+	}
+}
+
+// EOF
@@ -0,0 +1,79 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+)
+
+//--------------------
+// CHAIN
+//--------------------
+
+// Middleware wraps a handler with another one, e.g. to check or
+// modify requests and responses.
+type Middleware func(http.Handler) http.Handler
+
+// Chain combines a stack of middlewares. The first middleware is the
+// outermost one, so it sees the requests first. Chains are immutable,
+// Use returns a new chain. So a common base chain can be extended per
+// route.
+//
+//	base := web.NewChain(logging, recovery, web.JWTMiddleware(jwtConfig))
+//	rt.Handle(http.MethodGet, "/orders", base.Then(orders))
+//	rt.Handle(http.MethodDelete, "/orders", base.Use(adminOnly).Then(orders))
+type Chain struct {
+	middlewares []Middleware
+}
+
+// NewChain creates a chain of the given middlewares.
+func NewChain(middlewares ...Middleware) Chain {
+	return Chain{}.Use(middlewares...)
+}
+
+// Use returns a new chain with the given middlewares appended.
+func (c Chain) Use(middlewares ...Middleware) Chain {
+	all := make([]Middleware, 0, len(c.middlewares)+len(middlewares))
+	all = append(all, c.middlewares...)
+	for _, middleware := range middlewares {
+		if middleware == nil {
+			panic("need middleware")
+		}
+		all = append(all, middleware)
+	}
+	return Chain{
+		middlewares: all,
+	}
+}
+
+// Then wraps the handler with the middlewares of the chain and
+// returns the resulting handler.
+func (c Chain) Then(handler http.Handler) http.Handler {
+	if handler == nil {
+		panic("need handler")
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		handler = c.middlewares[i](handler)
+	}
+	return handler
+}
+
+// ThenFunc wraps the handler function with the middlewares of the
+// chain and returns the resulting handler.
+func (c Chain) ThenFunc(hf func(http.ResponseWriter, *http.Request)) http.Handler {
+	if hf == nil {
+		panic("need handler function")
+	}
+	return c.Then(http.HandlerFunc(hf))
+}
+
+// EOF
@@ -0,0 +1,124 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/web"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidChain tests the panics for invalid values passed
+// to a Chain.
+func TestInvalidChain(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	assert.Panics(func() {
+		web.NewChain(nil)
+	}, "need middleware")
+	assert.Panics(func() {
+		web.NewChain().Then(nil)
+	}, "need handler")
+	assert.Panics(func() {
+		web.NewChain().ThenFunc(nil)
+	}, "need handler function")
+}
+
+// TestChain tests the order of middlewares and the extension
+// of chains.
+func TestChain(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	base := web.NewChain(makeTraceMiddleware("a"), makeTraceMiddleware("b"))
+	extended := base.Use(makeTraceMiddleware("c"))
+	other := base.Use(makeTraceMiddleware("d"))
+
+	wa.Handle("/empty/", web.NewChain().ThenFunc(makeMethodEcho(assert)))
+	wa.Handle("/base/", base.ThenFunc(makeMethodEcho(assert)))
+	wa.Handle("/extended/", extended.ThenFunc(makeMethodEcho(assert)))
+	wa.Handle("/other/", other.ThenFunc(makeMethodEcho(assert)))
+
+	tests := []struct {
+		path  string
+		trace []string
+	}{
+		{"/empty/", nil},
+		{"/base/", []string{"a", "b"}},
+		{"/extended/", []string{"a", "b", "c"}},
+		{"/other/", []string{"a", "b", "d"}},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.path)
+		wreq := wa.CreateRequest(http.MethodGet, test.path)
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(http.StatusOK)
+		wresp.AssertBodyMatches("METHOD: GET!")
+		assert.Equal(wresp.Header().Get("X-Trace"), test.trace)
+	}
+}
+
+// TestChainMiddlewares tests the middlewares of the existing handlers.
+func TestChainMiddlewares(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	chain := web.NewChain(
+		web.CORSMiddleware(&web.CORSHandlerConfig{
+			AllowedOrigins: []string{"https://example.com"},
+		}),
+		web.JWTMiddleware(nil),
+		web.MetaMethodMiddleware(),
+	)
+
+	wa.Handle("/chain/", chain.Then(mmHandler{}))
+
+	// Preflight requests are answered before the token is checked.
+	wreq := wa.CreateRequest(http.MethodOptions, "/chain/")
+	wreq.Header().Set("Origin", "https://example.com")
+	wreq.Header().Set("Access-Control-Request-Method", http.MethodPost)
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusNoContent)
+	wresp.Header().AssertKeyValueEquals("Access-Control-Allow-Origin", "https://example.com")
+
+	// Requests without token are rejected.
+	wreq = wa.CreateRequest(http.MethodPut, "/chain/")
+	wreq.Header().Set("Origin", "https://example.com")
+	wresp = wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusUnauthorized)
+	wresp.Header().AssertKeyValueEquals("Access-Control-Allow-Origin", "https://example.com")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// makeTraceMiddleware creates a middleware adding its name to
+// the trace header of the response.
+func makeTraceMiddleware(name string) web.Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// EOF
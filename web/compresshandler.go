@@ -0,0 +1,444 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// CONSTANTS
+//--------------------
+
+// Content encodings supported by the CompressHandler.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// defaultMinSize is the default minimal size of compressed bodies.
+const defaultMinSize = 1024
+
+// compressedContentTypes contains the content types or their prefixes
+// which are typically already compressed.
+var compressedContentTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/zstd",
+}
+
+// uncompressedImageTypes contains the image types which are text and
+// so compressed anyway.
+var uncompressedImageTypes = []string{
+	"image/svg+xml",
+	"image/x-icon",
+	"image/bmp",
+}
+
+//--------------------
+// COMPRESS HANDLER
+//--------------------
+
+// CompressHandlerConfig allows to control how the compress handler
+// works. All values are optional. In this case bodies of at least
+// 1024 bytes are compressed with the default level if their content
+// type isn't already compressed, like images, videos, or archives.
+//
+// Level is the level of the gzip and zlib compression, from
+// gzip.HuffmanOnly to gzip.BestCompression. Zero stands for the
+// default level. MinSize is the minimal size of bodies to compress.
+// ExcludedContentTypes are content types or their prefixes ending
+// with a slash which are not compressed in addition to the already
+// compressed ones.
+type CompressHandlerConfig struct {
+	Level                int
+	MinSize              int
+	ExcludedContentTypes []string
+}
+
+// CompressHandler compresses the responses of the wrapped handler
+// with gzip or deflate depending on the Accept-Encoding header of the
+// request and its quality values. Small bodies, responses to HEAD or
+// range requests, and already encoded or compressed content are sent
+// unchanged. Flushing starts the compression of a streamed body
+// independent of its size. Strong ETags of compressed responses are
+// turned into weak ones. Panics of the wrapped handler are passed on
+// without writing a buffered body or finishing a compressed one.
+type CompressHandler struct {
+	handler       http.Handler
+	level         int
+	minSize       int
+	excludedTypes []string
+	gzipPool      sync.Pool
+	zlibPool      sync.Pool
+}
+
+// NewCompressHandler creates a handler compressing the responses of
+// the given handler.
+func NewCompressHandler(handler http.Handler, config *CompressHandlerConfig) *CompressHandler {
+	if handler == nil {
+		panic("need handler")
+	}
+	ch := &CompressHandler{
+		handler:       handler,
+		level:         gzip.DefaultCompression,
+		minSize:       defaultMinSize,
+		excludedTypes: compressedContentTypes,
+	}
+	if config != nil {
+		if config.Level != 0 {
+			if config.Level < gzip.HuffmanOnly || config.Level > gzip.BestCompression {
+				panic("invalid compression level " + strconv.Itoa(config.Level))
+			}
+			ch.level = config.Level
+		}
+		if config.MinSize > 0 {
+			ch.minSize = config.MinSize
+		}
+		ch.excludedTypes = append(ch.excludedTypes[:len(ch.excludedTypes):len(ch.excludedTypes)], config.ExcludedContentTypes...)
+	}
+	ch.gzipPool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, ch.level)
+		return w
+	}
+	ch.zlibPool.New = func() interface{} {
+		w, _ := zlib.NewWriterLevel(nil, ch.level)
+		return w
+	}
+	return ch
+}
+
+// CompressMiddleware returns a middleware wrapping handlers with a
+// CompressHandler using the given configuration.
+func CompressMiddleware(config *CompressHandlerConfig) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewCompressHandler(handler, config)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (ch *CompressHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	addVary(w.Header(), "Accept-Encoding")
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+		ch.handler.ServeHTTP(w, r)
+		return
+	}
+	cw := &compressResponseWriter{
+		ResponseWriter: w,
+		handler:        ch,
+		encoding:       encoding,
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			// Leave the response to an outer recovery.
+			cw.abort()
+			panic(v)
+		}
+		if err := cw.close(); err != nil {
+			logger.Errorf("compress handler: %v", err)
+		}
+	}()
+	ch.handler.ServeHTTP(cw, r)
+}
+
+// isCompressible checks if the content type is worth compressing.
+func (ch *CompressHandler) isCompressible(contentType string) bool {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, uncompressed := range uncompressedImageTypes {
+		if mediaType == uncompressed {
+			return true
+		}
+	}
+	for _, excluded := range ch.excludedTypes {
+		excluded = strings.ToLower(excluded)
+		if mediaType == excluded || (strings.HasSuffix(excluded, "/") && strings.HasPrefix(mediaType, excluded)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressor returns a pooled compressor for the encoding writing
+// to the writer.
+func (ch *CompressHandler) compressor(encoding string, w io.Writer) compressor {
+	if encoding == EncodingGzip {
+		gw := ch.gzipPool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return gw
+	}
+	zw := ch.zlibPool.Get().(*zlib.Writer)
+	zw.Reset(w)
+	return zw
+}
+
+// release returns the compressor to its pool.
+func (ch *CompressHandler) release(c compressor) {
+	switch tc := c.(type) {
+	case *gzip.Writer:
+		ch.gzipPool.Put(tc)
+	case *zlib.Writer:
+		ch.zlibPool.Put(tc)
+	}
+}
+
+//--------------------
+// COMPRESS RESPONSE WRITER
+//--------------------
+
+// compressor is implemented by the gzip and zlib writers.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressResponseWriter buffers the beginning of a body until it is
+// clear if it has to be compressed.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	handler    *CompressHandler
+	encoding   string
+	statusCode int
+	buf        []byte
+	decided    bool
+	compressor compressor
+}
+
+// WriteHeader implements http.ResponseWriter. Writing the header is
+// delayed until the decision about the compression, further calls
+// are ignored like by net/http.
+func (w *compressResponseWriter) WriteHeader(statusCode int) {
+	if w.decided || statusCode < http.StatusOK {
+		// Informational responses are passed directly.
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.statusCode != 0 {
+		return
+	}
+	w.statusCode = statusCode
+	if !bodyAllowed(statusCode) {
+		w.decide(false)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.compressor != nil {
+			return w.compressor.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.handler.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush implements http.Flusher. Flushing an undecided response starts
+// the compression if the content type allows it.
+func (w *compressResponseWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(true); err != nil {
+			logger.Errorf("compress handler: %v", err)
+			return
+		}
+	}
+	if w.compressor != nil {
+		if err := w.compressor.Flush(); err != nil {
+			logger.Errorf("compress handler: %v", err)
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, failure.New("response writer does not support hijacking")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.decided = true
+	}
+	return conn, rw, err
+}
+
+// decide checks if the response will be compressed, writes the header
+// and the buffered beginning of the body.
+func (w *compressResponseWriter) decide(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	if h.Get(httpx.HeaderContentType) == "" && len(w.buf) > 0 && bodyAllowed(w.statusCode) {
+		// Sniff before the body is compressed.
+		h.Set(httpx.HeaderContentType, http.DetectContentType(w.buf))
+	}
+	if compress {
+		compress = w.statusCode != http.StatusPartialContent &&
+			h.Get("Content-Encoding") == "" &&
+			w.handler.isCompressible(h.Get(httpx.HeaderContentType))
+	}
+	if compress {
+		if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < w.handler.minSize {
+			compress = false
+		}
+	}
+	if compress {
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			// The compressed body isn't byte-identical anymore.
+			h.Set("ETag", "W/"+etag)
+		}
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		w.compressor = w.handler.compressor(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// close writes a still buffered small body unchanged or finishes the
+// compression.
+func (w *compressResponseWriter) close() error {
+	if !w.decided {
+		if w.statusCode == 0 && len(w.buf) == 0 {
+			// Nothing written, leave it to net/http.
+			return nil
+		}
+		return w.decide(false)
+	}
+	if w.compressor == nil {
+		return nil
+	}
+	err := w.compressor.Close()
+	w.handler.release(w.compressor)
+	w.compressor = nil
+	return err
+}
+
+// abort drops a buffered body and releases the compressor without
+// finishing the compressed body, so that it is recognized as
+// incomplete.
+func (w *compressResponseWriter) abort() {
+	w.buf = nil
+	if w.compressor != nil {
+		w.handler.release(w.compressor)
+		w.compressor = nil
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// negotiateEncoding returns the supported encoding with the highest
+// quality in the Accept-Encoding header. In case of equal quality
+// gzip is preferred. An empty string means no compression.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[coding] = q
+	}
+	quality := func(coding string) float64 {
+		if q, ok := qualities[coding]; ok {
+			return q
+		}
+		if q, ok := qualities["*"]; ok {
+			return q
+		}
+		return 0
+	}
+	gq := quality(EncodingGzip)
+	dq := quality(EncodingDeflate)
+	switch {
+	case gq > 0 && gq >= dq:
+		return EncodingGzip
+	case dq > 0:
+		return EncodingDeflate
+	default:
+		return ""
+	}
+}
+
+// bodyAllowed checks if responses with the status code may have
+// a body.
+func bodyAllowed(statusCode int) bool {
+	return statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// addVary adds the field to the Vary header if not yet contained.
+func addVary(h http.Header, field string) {
+	for _, vary := range h["Vary"] {
+		for _, value := range strings.Split(vary, ",") {
+			if strings.EqualFold(strings.TrimSpace(value), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// EOF
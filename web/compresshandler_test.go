@@ -0,0 +1,306 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidCompressHandler tests the panics for invalid values
+// passed to the CompressHandler.
+func TestInvalidCompressHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	echo := makeMethodEcho(assert)
+
+	assert.Panics(func() {
+		web.NewCompressHandler(nil, nil)
+	}, "need handler")
+	assert.Panics(func() {
+		web.NewCompressHandler(echo, &web.CompressHandlerConfig{Level: 10})
+	}, "invalid compression level 10")
+}
+
+// TestCompressHandler tests the negotiation of the encoding and the
+// skipping of responses not worth compressing.
+func TestCompressHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	text := strings.Repeat("Hello, World! ", 100)
+	wa.Handle("/compress/", web.CompressMiddleware(&web.CompressHandlerConfig{
+		ExcludedContentTypes: []string{"application/pdf"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("type") {
+		case "small":
+			_, _ = w.Write([]byte("Hello, World!"))
+		case "png":
+			w.Header().Set(httpx.HeaderContentType, "image/png")
+			_, _ = w.Write([]byte(text))
+		case "svg":
+			w.Header().Set(httpx.HeaderContentType, "image/svg+xml")
+			_, _ = w.Write([]byte(text))
+		case "pdf":
+			w.Header().Set(httpx.HeaderContentType, "application/pdf")
+			_, _ = w.Write([]byte(text))
+		case "encoded":
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte(text))
+		case "empty":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set(httpx.HeaderContentType, "text/plain; charset=utf-8")
+			for i := 0; i < 10; i++ {
+				_, _ = w.Write([]byte(text[:140]))
+			}
+		}
+	})))
+
+	tests := []struct {
+		query          string
+		acceptEncoding string
+		statusCode     int
+		encoding       string
+	}{
+		{"", "gzip", http.StatusOK, "gzip"},
+		{"", "deflate", http.StatusOK, "deflate"},
+		{"", "gzip, deflate", http.StatusOK, "gzip"},
+		{"", "gzip;q=0.5, deflate", http.StatusOK, "deflate"},
+		{"", "deflate;q=0.5, *", http.StatusOK, "gzip"},
+		{"", "*;q=0.2, gzip;q=0", http.StatusOK, "deflate"},
+		{"", "gzip;q=0, deflate;q=0", http.StatusOK, ""},
+		{"", "br, identity", http.StatusOK, ""},
+		{"?type=small", "gzip", http.StatusOK, ""},
+		{"?type=png", "gzip", http.StatusOK, ""},
+		{"?type=svg", "gzip", http.StatusOK, "gzip"},
+		{"?type=pdf", "gzip", http.StatusOK, ""},
+		{"?type=encoded", "gzip", http.StatusOK, "br"},
+		{"?type=empty", "gzip", http.StatusNoContent, ""},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %q accepting %q", test.query, test.acceptEncoding)
+		wreq := wa.CreateRequest(http.MethodGet, "/compress/"+test.query)
+		wreq.Header().Set("Accept-Encoding", test.acceptEncoding)
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.Header().AssertKeyValueEquals("Vary", "Accept-Encoding")
+		assertHeaderEquals(assert, wresp, "Content-Encoding", test.encoding)
+		body := decompress(assert, test.encoding, wresp.Body())
+		switch test.query {
+		case "?type=small":
+			assert.Equal(body, "Hello, World!")
+			wresp.Header().AssertKeyValueEquals("Content-Length", "13")
+		case "?type=empty":
+			assert.Equal(body, "")
+		default:
+			assert.Equal(body, text)
+		}
+	}
+
+	// HEAD requests are not compressed.
+	wreq := wa.CreateRequest(http.MethodHead, "/compress/")
+	wreq.Header().Set("Accept-Encoding", "gzip")
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusOK)
+	assertHeaderEquals(assert, wresp, "Content-Encoding", "")
+}
+
+// TestCompressHandlerSniffing tests the detection of the content type
+// before the body is compressed.
+func TestCompressHandlerSniffing(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	html := "<html><body>" + strings.Repeat("<p>Hello, World!</p>", 100) + "</body></html>"
+	ch := web.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(html))
+	}), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	ch.ServeHTTP(w, r)
+
+	assert.Equal(w.Code, http.StatusOK)
+	assert.Equal(w.Header().Get(httpx.HeaderContentType), "text/html; charset=utf-8")
+	assert.Equal(w.Header().Get("Content-Encoding"), "gzip")
+	assert.Equal(decompress(assert, "gzip", w.Body.Bytes()), html)
+}
+
+// TestCompressHandlerHeaders tests repeated writing of the header
+// and the weakening of ETags.
+func TestCompressHandlerHeaders(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	text := strings.Repeat("Hello, World! ", 100)
+	ch := web.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag := r.URL.Query().Get("etag"); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.Header().Set(httpx.HeaderContentType, "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(text))
+	}), nil)
+
+	tests := []struct {
+		etag           string
+		acceptEncoding string
+		expected       string
+	}{
+		{`"4711"`, "gzip", `W/"4711"`},
+		{`W/"4711"`, "gzip", `W/"4711"`},
+		{`"4711"`, "", `"4711"`},
+		{"", "gzip", ""},
+	}
+	for _, test := range tests {
+		assert.Logf("testing ETag %q accepting %q", test.etag, test.acceptEncoding)
+		r := httptest.NewRequest(http.MethodGet, "/?etag="+url.QueryEscape(test.etag), nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		w := httptest.NewRecorder()
+		ch.ServeHTTP(w, r)
+
+		assert.Equal(w.Code, http.StatusCreated)
+		assert.Equal(w.Header().Get("Content-Encoding"), test.acceptEncoding)
+		assert.Equal(w.Header().Get("ETag"), test.expected)
+		assert.Equal(decompress(assert, test.acceptEncoding, w.Body.Bytes()), text)
+	}
+}
+
+// TestCompressHandlerStreaming tests the flushing of streamed and
+// compressed responses.
+func TestCompressHandlerStreaming(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	nextc := make(chan struct{})
+	wa.Handle("/stream/", web.NewCompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		assert.True(ok)
+		w.Header().Set(httpx.HeaderContentType, "text/event-stream")
+		for i := 0; i < 3; i++ {
+			_, _ = w.Write([]byte("data: ping\n\n"))
+			f.Flush()
+			<-nextc
+		}
+	}), nil))
+
+	req, err := http.NewRequest(http.MethodGet, wa.URL()+"/stream/", nil)
+	assert.NoError(err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(resp.Header.Get("Content-Encoding"), "gzip")
+
+	// Each event can be read before the handler continues.
+	gr, err := gzip.NewReader(resp.Body)
+	assert.NoError(err)
+	event := make([]byte, 12)
+	for i := 0; i < 3; i++ {
+		_, err = io.ReadFull(gr, event)
+		assert.NoError(err)
+		assert.Equal(string(event), "data: ping\n\n")
+		nextc <- struct{}{}
+	}
+	rest, err := ioutil.ReadAll(gr)
+	assert.NoError(err)
+	assert.Length(rest, 0)
+}
+
+// TestCompressHandlerPanic tests that panics do not commit buffered
+// bodies or finish compressed ones.
+func TestCompressHandlerPanic(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	text := strings.Repeat("Hello, World! ", 100)
+	wa.Handle("/panic/", web.NewChain(
+		web.RecoveryMiddleware(nil),
+		web.CompressMiddleware(nil),
+	).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(httpx.HeaderContentType, "text/plain; charset=utf-8")
+		if r.URL.Query().Get("size") == "small" {
+			_, _ = w.Write([]byte("Hello"))
+		} else {
+			_, _ = w.Write([]byte(text))
+		}
+		panic("ouch")
+	}))
+
+	// Small bodies are dropped for the problem.
+	wreq := wa.CreateRequest(http.MethodGet, "/panic/?size=small")
+	wreq.Header().Set("Accept-Encoding", "gzip")
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusInternalServerError)
+	wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, httpx.ContentTypeProblemJSON)
+	assertHeaderEquals(assert, wresp, "Content-Encoding", "")
+
+	// Compressed bodies stay incomplete.
+	req, err := http.NewRequest(http.MethodGet, wa.URL()+"/panic/", nil)
+	assert.NoError(err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(err)
+	defer resp.Body.Close()
+	assert.Equal(resp.StatusCode, http.StatusOK)
+	assert.Equal(resp.Header.Get("Content-Encoding"), "gzip")
+	gr, err := gzip.NewReader(resp.Body)
+	if err == nil {
+		_, err = ioutil.ReadAll(gr)
+	}
+	assert.ErrorMatch(err, ".*unexpected EOF.*")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// decompress decodes the body with the given encoding.
+func decompress(assert *asserts.Asserts, encoding string, body []byte) string {
+	var r io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	default:
+		return string(body)
+	}
+	assert.NoError(err)
+	decoded, err := ioutil.ReadAll(r)
+	assert.NoError(err)
+	return string(decoded)
+}
+
+// EOF
@@ -0,0 +1,25 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// CONTEXT
+//--------------------
+
+// contextKey is used for the storage of values in a context.
+type contextKey int
+
+// Keys of the values the handlers store in the request contexts.
+const (
+	claimsKey contextKey = iota
+	paramsKey
+	entityIDsKey
+	accessRecordKey
+)
+
+// EOF
@@ -0,0 +1,241 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------
+// CORS HANDLER
+//--------------------
+
+// CORSHandlerConfig allows to control which cross-origin requests
+// are allowed by the CORS handler. All values are optional. In this
+// case all origins may send the methods GET, HEAD, and POST with the
+// headers Accept, Accept-Language, Content-Language, and Content-Type.
+//
+// AllowedOrigins contain exact origins like "https://example.com",
+// origins with a wildcard subdomain like "https://*.example.com",
+// or "*" for all origins. If no listed origin matches the
+// AllowOriginFunc is asked.
+//
+// AllowedHeaders are the headers browsers may send, "*" allows all.
+// ExposedHeaders are the headers of responses scripts may read.
+// AllowCredentials allows requests with cookies or authorization
+// headers. It needs listed origins or the AllowOriginFunc, but not
+// all origins. MaxAge is the duration browsers may cache the results of
+// preflight requests.
+type CORSHandlerConfig struct {
+	AllowedOrigins   []string
+	AllowOriginFunc  func(origin string) bool
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// CORSHandler adds the headers for Cross-Origin Resource Sharing to
+// the responses of the wrapped handler. Preflight requests are
+// answered directly, all others are passed to the wrapped handler.
+// Requests of origins which are not allowed get no CORS headers, so
+// browsers reject their responses.
+type CORSHandler struct {
+	handler          http.Handler
+	allOrigins       bool
+	origins          map[string]bool
+	wildcards        [][2]string
+	originFunc       func(origin string) bool
+	methods          map[string]bool
+	allowedMethods   string
+	allHeaders       bool
+	headers          map[string]bool
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// NewCORSHandler creates a handler for Cross-Origin Resource Sharing
+// wrapping the given handler.
+func NewCORSHandler(handler http.Handler, config *CORSHandlerConfig) *CORSHandler {
+	if handler == nil {
+		panic("need handler")
+	}
+	if config == nil {
+		config = &CORSHandlerConfig{}
+	}
+	ch := &CORSHandler{
+		handler:          handler,
+		allOrigins:       len(config.AllowedOrigins) == 0 && config.AllowOriginFunc == nil,
+		origins:          make(map[string]bool),
+		originFunc:       config.AllowOriginFunc,
+		methods:          make(map[string]bool),
+		headers:          make(map[string]bool),
+		exposedHeaders:   canonicalHeaders(config.ExposedHeaders),
+		allowCredentials: config.AllowCredentials,
+	}
+	for _, origin := range config.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			ch.allOrigins = true
+		case strings.Contains(origin, "://*."):
+			i := strings.Index(origin, "*")
+			ch.wildcards = append(ch.wildcards, [2]string{origin[:i], origin[i+1:]})
+		default:
+			ch.origins[origin] = true
+		}
+	}
+	if ch.allOrigins && ch.allowCredentials {
+		panic("credentials cannot be allowed for all origins")
+	}
+	methods := config.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	for _, method := range methods {
+		ch.methods[strings.ToUpper(method)] = true
+	}
+	ch.allowedMethods = strings.ToUpper(strings.Join(methods, ", "))
+	headers := config.AllowedHeaders
+	if len(headers) == 0 {
+		headers = []string{"Accept", "Accept-Language", "Content-Language", "Content-Type"}
+	}
+	for _, header := range headers {
+		if header == "*" {
+			ch.allHeaders = true
+			continue
+		}
+		ch.headers[http.CanonicalHeaderKey(header)] = true
+	}
+	if config.MaxAge > 0 {
+		ch.maxAge = strconv.Itoa(int(config.MaxAge / time.Second))
+	}
+	return ch
+}
+
+// CORSMiddleware returns a middleware wrapping handlers with a
+// CORSHandler using the given configuration.
+func CORSMiddleware(config *CORSHandlerConfig) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewCORSHandler(handler, config)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (ch *CORSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+		ch.servePreflight(w, r)
+		return
+	}
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if origin != "" && ch.isOriginAllowed(origin) {
+		ch.setOrigin(w, origin)
+		if ch.exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", ch.exposedHeaders)
+		}
+	}
+	ch.handler.ServeHTTP(w, r)
+}
+
+// servePreflight answers a preflight request. The CORS headers are
+// only set if origin, method, and headers are allowed.
+func (ch *CORSHandler) servePreflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	headers := r.Header.Get("Access-Control-Request-Headers")
+	w.Header().Add("Vary", "Origin")
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	if origin == "" || !ch.isOriginAllowed(origin) || !ch.methods[method] || !ch.areHeadersAllowed(headers) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	ch.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", ch.allowedMethods)
+	if headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if ch.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", ch.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setOrigin sets the headers for an allowed origin.
+func (ch *CORSHandler) setOrigin(w http.ResponseWriter, origin string) {
+	if ch.allOrigins {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if ch.allowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// isOriginAllowed checks if the origin matches the allowed ones.
+func (ch *CORSHandler) isOriginAllowed(origin string) bool {
+	if ch.allOrigins {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if ch.origins[lower] {
+		return true
+	}
+	for _, wildcard := range ch.wildcards {
+		prefix, suffix := wildcard[0], wildcard[1]
+		if len(lower) > len(prefix)+len(suffix) && strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	if ch.originFunc != nil {
+		return ch.originFunc(origin)
+	}
+	return false
+}
+
+// areHeadersAllowed checks if all comma separated headers requested
+// by a preflight request are allowed.
+func (ch *CORSHandler) areHeadersAllowed(headers string) bool {
+	if ch.allHeaders || headers == "" {
+		return true
+	}
+	for _, header := range strings.Split(headers, ",") {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != "" && !ch.headers[header] {
+			return false
+		}
+	}
+	return true
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// canonicalHeaders returns the headers in canonical form as comma
+// separated list.
+func canonicalHeaders(headers []string) string {
+	canonical := make([]string, len(headers))
+	for i, header := range headers {
+		canonical[i] = http.CanonicalHeaderKey(header)
+	}
+	return strings.Join(canonical, ", ")
+}
+
+// EOF
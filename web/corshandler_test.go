@@ -0,0 +1,192 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/net/web"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidCORSHandler tests the panics for invalid values
+// passed to the CORSHandler.
+func TestInvalidCORSHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	echo := makeMethodEcho(assert)
+
+	assert.Panics(func() {
+		web.NewCORSHandler(nil, nil)
+	}, "need handler")
+	assert.Panics(func() {
+		web.NewCORSHandler(echo, &web.CORSHandlerConfig{
+			AllowCredentials: true,
+		})
+	}, "credentials cannot be allowed for all origins")
+	assert.Panics(func() {
+		web.NewCORSHandler(echo, &web.CORSHandlerConfig{
+			AllowedOrigins:   []string{"https://example.com", "*"},
+			AllowCredentials: true,
+		})
+	}, "credentials cannot be allowed for all origins")
+}
+
+// TestCORSHandlerRequests tests the CORS headers of non-preflight
+// requests.
+func TestCORSHandlerRequests(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	wa.Handle("/any/", web.NewCORSHandler(makeMethodEcho(assert), nil))
+	wa.Handle("/list/", web.NewCORSHandler(makeMethodEcho(assert), &web.CORSHandlerConfig{
+		AllowedOrigins: []string{"https://example.com", "https://*.example.org"},
+		AllowOriginFunc: func(origin string) bool {
+			return strings.HasSuffix(origin, ".localhost:8080")
+		},
+		ExposedHeaders:   []string{"x-request-id"},
+		AllowCredentials: true,
+	}))
+
+	tests := []struct {
+		path        string
+		origin      string
+		allowOrigin string
+		credentials string
+		expose      string
+	}{
+		{"/any/", "", "", "", ""},
+		{"/any/", "https://example.com", "*", "", ""},
+		{"/list/", "", "", "", ""},
+		{"/list/", "https://example.com", "https://example.com", "true", "X-Request-Id"},
+		{"/list/", "https://EXAMPLE.com", "https://EXAMPLE.com", "true", "X-Request-Id"},
+		{"/list/", "https://api.example.org", "https://api.example.org", "true", "X-Request-Id"},
+		{"/list/", "https://a.b.example.org", "https://a.b.example.org", "true", "X-Request-Id"},
+		{"/list/", "https://example.org", "", "", ""},
+		{"/list/", "http://api.example.org", "", "", ""},
+		{"/list/", "https://app.localhost:8080", "https://app.localhost:8080", "true", "X-Request-Id"},
+		{"/list/", "https://example.net", "", "", ""},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s with origin %q", test.path, test.origin)
+		wreq := wa.CreateRequest(http.MethodGet, test.path)
+		if test.origin != "" {
+			wreq.Header().Set("Origin", test.origin)
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(http.StatusOK)
+		wresp.AssertBodyMatches("METHOD: GET!")
+		wresp.Header().AssertKeyValueEquals("Vary", "Origin")
+		assertHeaderEquals(assert, wresp, "Access-Control-Allow-Origin", test.allowOrigin)
+		assertHeaderEquals(assert, wresp, "Access-Control-Allow-Credentials", test.credentials)
+		assertHeaderEquals(assert, wresp, "Access-Control-Expose-Headers", test.expose)
+	}
+}
+
+// TestCORSHandlerPreflight tests the answers to preflight requests.
+func TestCORSHandlerPreflight(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	mh := web.NewMethodHandler()
+	mh.HandleFunc(http.MethodGet, makeMethodEcho(assert))
+	mh.HandleFunc(http.MethodPut, makeMethodEcho(assert))
+
+	wa.Handle("/default/", web.NewCORSHandler(mh, nil))
+	wa.Handle("/config/", web.NewCORSHandler(mh, &web.CORSHandlerConfig{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPut},
+		AllowedHeaders:   []string{"Authorization", "content-type"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}))
+	wa.Handle("/headers/", web.NewCORSHandler(mh, &web.CORSHandlerConfig{
+		AllowedHeaders: []string{"*"},
+	}))
+
+	tests := []struct {
+		path         string
+		origin       string
+		method       string
+		headers      string
+		allowOrigin  string
+		allowMethods string
+		allowHeaders string
+		maxAge       string
+	}{
+		{"/default/", "https://example.com", "GET", "", "*", "GET, HEAD, POST", "", ""},
+		{"/default/", "https://example.com", "POST", "content-type", "*", "GET, HEAD, POST", "content-type", ""},
+		{"/default/", "https://example.com", "PUT", "", "", "", "", ""},
+		{"/default/", "https://example.com", "GET", "authorization", "", "", "", ""},
+		{"/default/", "", "GET", "", "", "", "", ""},
+		{"/config/", "https://example.com", "PUT", "Authorization, Content-Type", "https://example.com", "GET, PUT", "Authorization, Content-Type", "600"},
+		{"/config/", "https://example.com", "DELETE", "", "", "", "", ""},
+		{"/config/", "https://example.com", "GET", "authorization, x-trace", "", "", "", ""},
+		{"/config/", "https://example.net", "GET", "", "", "", "", ""},
+		{"/headers/", "https://example.com", "GET", "x-trace, x-span", "*", "GET, HEAD, POST", "x-trace, x-span", ""},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s with origin %q, method %s, and headers %q", test.path, test.origin, test.method, test.headers)
+		wreq := wa.CreateRequest(http.MethodOptions, test.path)
+		if test.origin != "" {
+			wreq.Header().Set("Origin", test.origin)
+		}
+		wreq.Header().Set("Access-Control-Request-Method", test.method)
+		if test.headers != "" {
+			wreq.Header().Set("Access-Control-Request-Headers", test.headers)
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(http.StatusNoContent)
+		wresp.AssertBodyMatches("")
+		assert.Equal(wresp.Header().Get("Vary"), []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"})
+		assertHeaderEquals(assert, wresp, "Access-Control-Allow-Origin", test.allowOrigin)
+		assertHeaderEquals(assert, wresp, "Access-Control-Allow-Methods", test.allowMethods)
+		assertHeaderEquals(assert, wresp, "Access-Control-Allow-Headers", test.allowHeaders)
+		assertHeaderEquals(assert, wresp, "Access-Control-Max-Age", test.maxAge)
+		// Preflight requests are answered by the CORS handler.
+		assertHeaderEquals(assert, wresp, "Allow", "")
+	}
+
+	// OPTIONS requests without requested method pass.
+	wreq := wa.CreateRequest(http.MethodOptions, "/config/")
+	wreq.Header().Set("Origin", "https://example.com")
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusNoContent)
+	wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS, PUT")
+	wresp.Header().AssertKeyValueEquals("Access-Control-Allow-Origin", "https://example.com")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// assertHeaderEquals checks the value of a response header. An
+// empty expected value means the header must not exist.
+func assertHeaderEquals(assert *asserts.Asserts, wresp *environments.WebResponse, key, expected string) {
+	values := wresp.Header().Get(key)
+	if expected == "" {
+		assert.Length(values, 0, key+" header exists")
+		return
+	}
+	assert.Equal(values, []string{expected}, key+" header differs")
+}
+
+// EOF
@@ -0,0 +1,180 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"tideland.dev/go/net/jwt/token"
+)
+
+//--------------------
+// DPOP
+//--------------------
+
+const (
+	// defaultDPoPMaxAge is the default maximum age of DPoP proofs.
+	defaultDPoPMaxAge = time.Minute
+
+	// defaultDPoPMaxProofIDs is the default maximum number of kept
+	// proof identifiers.
+	defaultDPoPMaxProofIDs = 100000
+)
+
+// DPoPConfig enables the checking of DPoP proofs (RFC 9449) by a
+// JWTHandler. Access tokens with the scheme "DPoP" then need a valid
+// proof in the "DPoP" header and have to be bound to its key by the
+// "jkt" member of their "cnf" claim. Bearer tokens bound to a key are
+// rejected, all bearer tokens if Required is set.
+//
+// The "iat" claim of proofs may differ by MaxAge from now, default
+// is one minute. The identifiers of proofs for valid and bound access
+// tokens are kept to reject replayed proofs. MaxProofIDs limits their
+// number, default is 100,000. When reached further proofs are rejected
+// until older identifiers expire. URL returns the URL of the request
+// the proofs are checked against. As default it is built out of TLS
+// state, host, and path.
+type DPoPConfig struct {
+	Required    bool
+	MaxAge      time.Duration
+	MaxProofIDs int
+	URL         func(r *http.Request) string
+}
+
+// dpopProof contains the thumbprint of the key and the identifier
+// of a verified proof.
+type dpopProof struct {
+	thumbprint string
+	id         string
+}
+
+// dpopValidator checks the DPoP proofs of requests.
+type dpopValidator struct {
+	required    bool
+	maxAge      time.Duration
+	maxProofIDs int
+	url         func(r *http.Request) string
+	mu          sync.Mutex
+	seen        map[string]time.Time
+	sweep       time.Time
+}
+
+// newDPoPValidator creates a validator based on the configuration.
+func newDPoPValidator(config *DPoPConfig) *dpopValidator {
+	dv := &dpopValidator{
+		required:    config.Required,
+		maxAge:      defaultDPoPMaxAge,
+		maxProofIDs: defaultDPoPMaxProofIDs,
+		url:         requestURL,
+		seen:        make(map[string]time.Time),
+	}
+	if config.MaxAge > 0 {
+		dv.maxAge = config.MaxAge
+	}
+	if config.MaxProofIDs > 0 {
+		dv.maxProofIDs = config.MaxProofIDs
+	}
+	if config.URL != nil {
+		dv.url = config.URL
+	}
+	return dv
+}
+
+// requestToken returns the access token of the request and, for
+// the scheme "DPoP", the valid proof. In case of a failure the
+// message describes the reason.
+func (dv *dpopValidator) requestToken(r *http.Request) (string, *dpopProof, string) {
+	scheme, st, err := token.RequestAuthorization(r)
+	if err != nil {
+		return "", nil, err.Error()
+	}
+	if scheme == "Bearer" {
+		if dv.required {
+			return "", nil, "the access token has to be bound by DPoP"
+		}
+		return st, nil, ""
+	}
+	proofs := r.Header[http.CanonicalHeaderKey("DPoP")]
+	if len(proofs) != 1 {
+		return "", nil, "the request needs exactly one DPoP proof"
+	}
+	proof, jkt, err := token.VerifyDPoPProof(proofs[0], r.Method, dv.url(r), st, dv.maxAge)
+	if err != nil {
+		return "", nil, err.Error()
+	}
+	jti, _ := proof.Claims().Identifier()
+	return st, &dpopProof{jkt, jti}, ""
+}
+
+// checkProof checks if the claims of the verified access token are
+// bound to the key of the proof, or not bound at all in case of bearer
+// tokens. Only then the proof identifier is checked for a replay and
+// kept. In case of a failure message and status code describe the
+// reason.
+func (dv *dpopValidator) checkProof(claims token.Claims, proof *dpopProof) (string, int) {
+	cnf, _ := claims.Confirmation()
+	switch {
+	case proof == nil && cnf.JWKThumbprint != "":
+		return "the access token is bound to a DPoP key", http.StatusUnauthorized
+	case proof == nil:
+		return "", http.StatusOK
+	case cnf.JWKThumbprint != proof.thumbprint:
+		return "the access token is not bound to the DPoP key", http.StatusUnauthorized
+	}
+	return dv.keep(proof.thumbprint + ":" + proof.id)
+}
+
+// keep stores the proof identifier if it hasn't been seen before and
+// the maximum number isn't reached. Identifiers are removed when their
+// proofs are too old anyway. In case of a failure message and status
+// code describe the reason.
+func (dv *dpopValidator) keep(id string) (string, int) {
+	dv.mu.Lock()
+	defer dv.mu.Unlock()
+	now := time.Now()
+	if now.After(dv.sweep) || len(dv.seen) >= dv.maxProofIDs {
+		for seenID, expires := range dv.seen {
+			if now.After(expires) {
+				delete(dv.seen, seenID)
+			}
+		}
+		dv.sweep = now.Add(dv.maxAge)
+	}
+	if _, ok := dv.seen[id]; ok {
+		return "the DPoP proof has already been used", http.StatusUnauthorized
+	}
+	if len(dv.seen) >= dv.maxProofIDs {
+		return "too many DPoP proofs in use", http.StatusServiceUnavailable
+	}
+	dv.seen[id] = now.Add(2 * dv.maxAge)
+	return "", http.StatusOK
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// requestURL returns the absolute URL of the request.
+func requestURL(r *http.Request) string {
+	if r.URL.IsAbs() {
+		return r.URL.String()
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.EscapedPath()
+}
+
+// EOF
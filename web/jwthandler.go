@@ -12,42 +12,108 @@ package web // import "tideland.dev/go/net/web"
 //--------------------
 
 import (
-	"encoding/json"
-	"encoding/xml"
+	"context"
 	"net/http"
-	"strconv"
 	"time"
 
-	"tideland.dev/go/net/httpx"
 	"tideland.dev/go/net/jwt/cache"
 	"tideland.dev/go/net/jwt/token"
-	"tideland.dev/go/trace/logger"
 )
 
 //--------------------
 // JWT HANDLER
 //--------------------
 
+// IsAuthenticated returns true if the context of a request passed
+// through a JWTHandler carries verified claims. It is false for
+// requests passed anonymously in optional mode.
+func IsAuthenticated(ctx context.Context) bool {
+	_, ok := ClaimsFromContext(ctx)
+	return ok
+}
+
+// ClaimsFromContext returns the claims verified by a JWTHandler,
+// if any. They are also available when an alternative TokenVerifier
+// is used and so no JWT is stored in the context.
+func ClaimsFromContext(ctx context.Context) (token.Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(token.Claims)
+	return claims, ok
+}
+
+// TokenVerifier allows to plug alternative ways of checking the
+// bearer token of a request into the JWTHandler, e.g. the
+// introspection of opaque tokens. It returns the claims of the
+// token or an error if it is not valid.
+type TokenVerifier interface {
+	VerifyToken(ctx context.Context, st string) (token.Claims, error)
+}
+
 // JWTHandlerConfig allows to control how the JWT handler works.
 // All values are optional. In this case tokens are only decoded
 // without using a cache, validated for the current time plus/minus
 // a minute leeway, and there's no user defined gatekeeper function
 // running afterwards.
+//
+// When Optional is set requests without a token are passed
+// anonymously to the wrapped handler, only requests with a token
+// are checked. Additionally setting IgnoreInvalid lets requests
+// with invalid tokens pass anonymously too instead of rejecting
+// them.
+//
+// A KeyFunc is used instead of the Key to choose the verification
+// key per token, e.g. out of a JSON Web Key Set. A Verifier replaces
+// the decoding or verification of the token with cache and key. If
+// Issuer or Algorithms are set the "iss" claim and the algorithm of
+// the token have to match.
+//
+// Verification keys are checked against the algorithm of the token
+// as defined in RFC 7518, e.g. the curve of ECDSA keys, the minimal
+// size of RSA keys, or the length of HMAC secrets. AllowWeakKeys
+// disables these checks. DPoP enables the checking of DPoP proofs
+// and key bound access tokens.
+//
+// When CertificateBound is set the "x5t#S256" member of the "cnf"
+// claim of tokens has to match the thumbprint of the client
+// certificate of the mutual TLS connection as defined in RFC 8705.
+// Tokens without this member are not bound and pass.
+//
+// ErrorRenderer writes the problem details of rejected requests,
+// default is RenderProblem.
 type JWTHandlerConfig struct {
-	Cache      *cache.Cache
-	Key        token.Key
-	Leeway     time.Duration
-	Gatekeeper func(w http.ResponseWriter, r *http.Request, claims token.Claims) error
+	Cache            *cache.Cache
+	Key              token.Key
+	KeyFunc          token.KeyFunc
+	Verifier         TokenVerifier
+	Issuer           string
+	Algorithms       []token.Algorithm
+	Leeway           time.Duration
+	Optional         bool
+	IgnoreInvalid    bool
+	AllowWeakKeys    bool
+	DPoP             *DPoPConfig
+	CertificateBound bool
+	Gatekeeper       func(w http.ResponseWriter, r *http.Request, claims token.Claims) error
+	ErrorRenderer    ErrorRenderer
 }
 
 // JWTHandler checks for a valid token and then runs
 // a gatekeeper function.
 type JWTHandler struct {
-	handler    http.Handler
-	cache      *cache.Cache
-	key        token.Key
-	leeway     time.Duration
-	gatekeeper func(w http.ResponseWriter, r *http.Request, claims token.Claims) error
+	handler          http.Handler
+	cache            *cache.Cache
+	key              token.Key
+	keyFunc          token.KeyFunc
+	verifier         TokenVerifier
+	issuer           string
+	algorithms       []token.Algorithm
+	leeway           time.Duration
+	optional         bool
+	ignoreInvalid    bool
+	allowWeakKeys    bool
+	dpop             *dpopValidator
+	certificateBound bool
+	gatekeeper       func(w http.ResponseWriter, r *http.Request, claims token.Claims) error
+	renderer         ErrorRenderer
 }
 
 // NewJWTHandler creates a handler checking for a valid JSON
@@ -64,94 +130,252 @@ func NewJWTHandler(handler http.Handler, config *JWTHandlerConfig) *JWTHandler {
 		if config.Key != nil {
 			jw.key = config.Key
 		}
+		if config.KeyFunc != nil {
+			jw.keyFunc = config.KeyFunc
+		}
+		if config.Verifier != nil {
+			jw.verifier = config.Verifier
+		}
+		jw.issuer = config.Issuer
+		jw.algorithms = config.Algorithms
 		if config.Leeway != 0 {
 			jw.leeway = config.Leeway
 		}
+		jw.optional = config.Optional
+		jw.ignoreInvalid = config.IgnoreInvalid
+		jw.allowWeakKeys = config.AllowWeakKeys
+		if config.DPoP != nil {
+			jw.dpop = newDPoPValidator(config.DPoP)
+		}
+		jw.certificateBound = config.CertificateBound
 		if config.Gatekeeper != nil {
 			jw.gatekeeper = config.Gatekeeper
 		}
+		jw.renderer = config.ErrorRenderer
 	}
 	return jw
 }
 
+// JWTMiddleware returns a middleware wrapping handlers with a
+// JWTHandler using the given configuration.
+func JWTMiddleware(config *JWTHandlerConfig) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewJWTHandler(handler, config)
+	}
+}
+
 // ServeHTTP implements the http.Handler interface. It checks for an existing
-// and valid token before calling the wrapped handler.
+// and valid token before calling the wrapped handler. The claims and, if
+// not verified by a TokenVerifier, the token are passed to the wrapped
+// handler via the request context. The subject is also set in the
+// record of an outer AccessLogHandler.
 func (jw *JWTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if jw.isAuthorized(w, r) {
-		jw.handler.ServeHTTP(w, r)
+	claims, jwt, ok := jw.isAuthorized(w, r)
+	if !ok {
+		return
 	}
+	if claims != nil {
+		subject, _ := claims.Subject()
+		setAccessSubject(r, subject)
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		if jwt != nil {
+			ctx = token.NewContext(ctx, jwt)
+		}
+		r = r.WithContext(ctx)
+	}
+	jw.handler.ServeHTTP(w, r)
 }
 
 // isAuthorized checks the request for a valid token and if configured
-// asks the gatekeepr if the request may pass.
-func (jw *JWTHandler) isAuthorized(w http.ResponseWriter, r *http.Request) bool {
+// asks the gatekeepr if the request may pass. In optional mode nil
+// claims are returned for requests passing anonymously.
+func (jw *JWTHandler) isAuthorized(w http.ResponseWriter, r *http.Request) (token.Claims, *token.JWT, bool) {
+	if jw.optional && r.Header.Get("Authorization") == "" {
+		return nil, nil, true
+	}
+	claims, jwt, msg, statusCode := jw.retrieve(r)
+	if claims == nil {
+		if jw.optional && jw.ignoreInvalid {
+			return nil, nil, true
+		}
+		jw.deny(w, r, msg, statusCode)
+		return nil, nil, false
+	}
+	if jw.gatekeeper != nil {
+		err := jw.gatekeeper(w, r, claims)
+		if err != nil {
+			jw.deny(w, r, "access rejected by gatekeeper: "+err.Error(), http.StatusUnauthorized)
+			return nil, nil, false
+		}
+	}
+	// All fine.
+	return claims, jwt, true
+}
+
+// retrieve reads the token of the request and checks its validity. In
+// case of a failure the claims are nil and message and status code
+// describe the reason.
+func (jw *JWTHandler) retrieve(r *http.Request) (token.Claims, *token.JWT, string, int) {
+	st, proof, msg := jw.requestToken(r)
+	if msg != "" {
+		return nil, nil, msg, http.StatusUnauthorized
+	}
+	var claims token.Claims
+	var jwt *token.JWT
+	var statusCode int
+	if jw.verifier != nil {
+		claims, msg, statusCode = jw.retrieveVerified(r, st)
+	} else {
+		claims, jwt, msg, statusCode = jw.retrieveJWT(st)
+	}
+	if claims == nil {
+		return nil, nil, msg, statusCode
+	}
+	if jw.certificateBound {
+		if msg = checkCertificateBinding(r, claims); msg != "" {
+			return nil, nil, msg, http.StatusUnauthorized
+		}
+	}
+	if jw.dpop != nil {
+		if msg, statusCode = jw.dpop.checkProof(claims, proof); msg != "" {
+			return nil, nil, msg, statusCode
+		}
+	}
+	return claims, jwt, "", http.StatusOK
+}
+
+// requestToken returns the token of the request and, in case of DPoP,
+// the verified proof. In case of a failure the message describes the
+// reason.
+func (jw *JWTHandler) requestToken(r *http.Request) (string, *dpopProof, string) {
+	if jw.dpop != nil {
+		return jw.dpop.requestToken(r)
+	}
+	st, err := token.RequestToken(r)
+	if err != nil {
+		return "", nil, err.Error()
+	}
+	return st, nil, ""
+}
+
+// retrieveJWT decodes or verifies the JSON Web Token and checks its
+// validity.
+func (jw *JWTHandler) retrieveJWT(st string) (token.Claims, *token.JWT, string, int) {
 	var jwt *token.JWT
 	var err error
 	switch {
-	case jw.cache != nil && jw.key != nil:
-		jwt, err = jw.cache.RequestVerify(r, jw.key)
-	case jw.cache != nil && jw.key == nil:
-		jwt, err = jw.cache.RequestDecode(r)
-	case jw.cache == nil && jw.key != nil:
-		jwt, err = token.RequestVerify(r, jw.key)
+	case jw.keyFunc != nil:
+		keyFunc := jw.keyFunc
+		if !jw.allowWeakKeys {
+			keyFunc = token.StrictKeyFunc(keyFunc)
+		}
+		jwt, err = token.VerifyWithKeyFunc(st, keyFunc)
+	case jw.cache != nil:
+		jwt, err = jw.cache.Get(st)
+		if err == nil && jwt == nil {
+			if jw.key != nil {
+				jwt, err = token.Verify(st, jw.key)
+			} else {
+				jwt, err = token.Decode(st)
+			}
+			if err == nil {
+				_, err = jw.cache.Put(jwt)
+			}
+		}
+	case jw.key != nil:
+		jwt, err = token.Verify(st, jw.key)
 	default:
-		jwt, err = token.RequestDecode(r)
+		jwt, err = token.Decode(st)
 	}
 	// Now do the checks.
 	if err != nil {
-		jw.deny(w, r, err.Error(), http.StatusUnauthorized)
-		return false
+		return nil, nil, err.Error(), http.StatusUnauthorized
 	}
 	if jwt == nil {
-		jw.deny(w, r, "no JSON Web Token", http.StatusUnauthorized)
-		return false
+		return nil, nil, "no JSON Web Token", http.StatusUnauthorized
+	}
+	if jw.keyFunc == nil && jw.key != nil && !jw.allowWeakKeys {
+		if err = jwt.Algorithm().ValidateKey(jw.key); err != nil {
+			return nil, nil, "the JSON Web Token key is not valid: " + err.Error(), http.StatusUnauthorized
+		}
+	}
+	if !jw.isAllowedAlgorithm(jwt.Algorithm()) {
+		return nil, nil, "the JSON Web Token algorithm '" + string(jwt.Algorithm()) + "' is not allowed", http.StatusUnauthorized
 	}
 	if !jwt.IsValid(jw.leeway) {
-		jw.deny(w, r, "the JSON Web Token claims 'nbf' and/or 'exp' are not valid", http.StatusForbidden)
-		return false
+		return nil, nil, "the JSON Web Token claims 'nbf' and/or 'exp' are not valid", http.StatusForbidden
 	}
-	if jw.gatekeeper != nil {
-		err := jw.gatekeeper(w, r, jwt.Claims())
-		if err != nil {
-			jw.deny(w, r, "access rejected by gatekeeper: "+err.Error(), http.StatusUnauthorized)
-			return false
-		}
+	claims := jwt.Claims()
+	if claims == nil {
+		claims = token.NewClaims()
 	}
-	// All fine.
-	return true
+	if !jw.isAllowedIssuer(claims) {
+		return nil, nil, "the JSON Web Token claim 'iss' is not valid", http.StatusUnauthorized
+	}
+	return claims, jwt, "", http.StatusOK
 }
 
-// deny sends a negative feedback to the caller.
-func (jw *JWTHandler) deny(w http.ResponseWriter, r *http.Request, msg string, statusCode int) {
-	feedback := map[string]string{
-		"statusCode": strconv.Itoa(statusCode),
-		"message":    msg,
+// retrieveVerified lets the configured verifier check the token.
+func (jw *JWTHandler) retrieveVerified(r *http.Request, st string) (token.Claims, string, int) {
+	claims, err := jw.verifier.VerifyToken(r.Context(), st)
+	if err != nil {
+		return nil, "cannot verify the token: " + err.Error(), http.StatusUnauthorized
 	}
-	switch {
-	case httpx.ContainsContentType(r.Header, httpx.ContentTypeJSON):
-		b, _ := json.Marshal(feedback)
-		w.Header().Set(httpx.HeaderContentType, httpx.ContentTypeJSON)
-		w.WriteHeader(statusCode)
-		if _, err := w.Write(b); err != nil {
-			logger.Errorf("JWT handler: %v", err)
-			return
-		}
-	case httpx.ContainsContentType(r.Header, httpx.ContentTypeXML):
-		b, _ := xml.Marshal(feedback)
-		w.Header().Set(httpx.HeaderContentType, httpx.ContentTypeXML)
-		w.WriteHeader(statusCode)
-		if _, err := w.Write(b); err != nil {
-			logger.Errorf("JWT handler: %v", err)
-			return
-		}
-	default:
-		w.Header().Set(httpx.HeaderContentType, httpx.ContentTypePlain)
-		w.WriteHeader(statusCode)
-		if _, err := w.Write([]byte(msg)); err != nil {
-			logger.Errorf("JWT handler: %v", err)
-			return
+	if claims == nil {
+		claims = token.NewClaims()
+	}
+	if !claims.IsValid(jw.leeway) {
+		return nil, "the token claims 'nbf' and/or 'exp' are not valid", http.StatusForbidden
+	}
+	if !jw.isAllowedIssuer(claims) {
+		return nil, "the token claim 'iss' is not valid", http.StatusUnauthorized
+	}
+	return claims, "", http.StatusOK
+}
+
+// checkCertificateBinding checks if bound claims match the client
+// certificate of the request. In case of a failure the message
+// describes the reason.
+func checkCertificateBinding(r *http.Request, claims token.Claims) string {
+	cnf, _ := claims.Confirmation()
+	if cnf.X509Thumbprint == "" {
+		return ""
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "the access token is bound to a client certificate"
+	}
+	if cnf.X509Thumbprint != token.CertificateThumbprint(r.TLS.PeerCertificates[0]) {
+		return "the access token is not bound to the client certificate"
+	}
+	return ""
+}
+
+// isAllowedAlgorithm checks if the algorithm is allowed. Without
+// configured algorithms all are allowed.
+func (jw *JWTHandler) isAllowedAlgorithm(algorithm token.Algorithm) bool {
+	if len(jw.algorithms) == 0 {
+		return true
+	}
+	for _, allowed := range jw.algorithms {
+		if allowed == algorithm {
+			return true
 		}
 	}
+	return false
+}
+
+// isAllowedIssuer checks if the claims contain the configured issuer.
+func (jw *JWTHandler) isAllowedIssuer(claims token.Claims) bool {
+	if jw.issuer == "" {
+		return true
+	}
+	iss, ok := claims.Issuer()
+	return ok && iss == jw.issuer
+}
+
+// deny sends a negative feedback to the caller.
+func (jw *JWTHandler) deny(w http.ResponseWriter, r *http.Request, msg string, statusCode int) {
+	writeProblem(jw.renderer, w, r, statusCode, msg)
 }
 
 // EOF
@@ -12,9 +12,21 @@ package web_test // import "tideland.dev/go/net/web_test"
 //--------------------
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
+	"io/ioutil"
+	"math/big"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"tideland.dev/go/audit/asserts"
 	"tideland.dev/go/audit/environments"
@@ -39,7 +51,7 @@ func TestJWTHandler(t *testing.T) {
 		assert.NoError(err)
 	})
 	jwtWrapper := web.NewJWTHandler(handler, &web.JWTHandlerConfig{
-		Key: []byte("secret"),
+		Key: []byte(secret),
 		Gatekeeper: func(w http.ResponseWriter, r *http.Request, claims token.Claims) error {
 			access, ok := claims.GetString("access")
 			if !ok || access != "allowed" {
@@ -68,7 +80,7 @@ func TestJWTHandler(t *testing.T) {
 			statusCode:  http.StatusUnauthorized,
 			body:        "cannot verify the signature",
 		}, {
-			key:         "secret",
+			key:         secret,
 			accessClaim: "allowed",
 			statusCode:  http.StatusOK,
 			body:        "request passed",
@@ -78,7 +90,7 @@ func TestJWTHandler(t *testing.T) {
 			statusCode:  http.StatusUnauthorized,
 			body:        "cannot verify the signature",
 		}, {
-			key:         "secret",
+			key:         secret,
 			accessClaim: "forbidden",
 			statusCode:  http.StatusUnauthorized,
 			body:        "access rejected by gatekeeper: access is not allowed",
@@ -100,4 +112,458 @@ func TestJWTHandler(t *testing.T) {
 	}
 }
 
+// TestJWTHandlerOptional tests the optional authentication mode
+// of the JWTHandler.
+func TestJWTHandlerOptional(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reply := "anonymous"
+		if web.IsAuthenticated(r.Context()) {
+			jwt, ok := token.FromContext(r.Context())
+			assert.True(ok)
+			reply, _ = jwt.Claims().Subject()
+		}
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(reply))
+		assert.NoError(err)
+	})
+	wa.Handle("/optional/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key:      []byte(secret),
+		Optional: true,
+	}))
+	wa.Handle("/ignore/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key:           []byte(secret),
+		Optional:      true,
+		IgnoreInvalid: true,
+	}))
+
+	tests := []struct {
+		path       string
+		key        string
+		statusCode int
+		body       string
+	}{
+		{
+			path:       "/optional/",
+			key:        "",
+			statusCode: http.StatusOK,
+			body:       "anonymous",
+		}, {
+			path:       "/optional/",
+			key:        secret,
+			statusCode: http.StatusOK,
+			body:       "john",
+		}, {
+			path:       "/optional/",
+			key:        "unknown",
+			statusCode: http.StatusUnauthorized,
+			body:       "cannot verify the signature",
+		}, {
+			path:       "/ignore/",
+			key:        "",
+			statusCode: http.StatusOK,
+			body:       "anonymous",
+		}, {
+			path:       "/ignore/",
+			key:        secret,
+			statusCode: http.StatusOK,
+			body:       "john",
+		}, {
+			path:       "/ignore/",
+			key:        "unknown",
+			statusCode: http.StatusOK,
+			body:       "anonymous",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s / %s", i, test.path, test.key)
+		wreq := wa.CreateRequest(http.MethodGet, test.path)
+		if test.key != "" {
+			claims := token.NewClaims()
+			claims.SetSubject("john")
+			jwt, err := token.Encode(claims, []byte(test.key), token.HS512)
+			assert.NoError(err)
+			wreq.Header().Set("Authorization", "Bearer "+jwt.String())
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches(test.body)
+	}
+}
+
+// TestJWTHandlerVerifier tests the usage of an alternative
+// token verifier by the JWTHandler.
+func TestJWTHandlerVerifier(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := web.ClaimsFromContext(r.Context())
+		assert.True(ok)
+		_, ok = token.FromContext(r.Context())
+		assert.False(ok)
+		sub, _ := claims.Subject()
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(sub))
+		assert.NoError(err)
+	})
+	wa.Handle("/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Verifier: stubVerifier{},
+	}))
+
+	tests := []struct {
+		token      string
+		statusCode int
+		body       string
+	}{
+		{
+			token:      "",
+			statusCode: http.StatusUnauthorized,
+			body:       "request contains no authorization header",
+		}, {
+			token:      "opaque-john",
+			statusCode: http.StatusOK,
+			body:       "john",
+		}, {
+			token:      "opaque-expired",
+			statusCode: http.StatusForbidden,
+			body:       "claims 'nbf' and/or 'exp' are not valid",
+		}, {
+			token:      "opaque-unknown",
+			statusCode: http.StatusUnauthorized,
+			body:       "cannot verify the token: token is not active",
+		},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.token)
+		wreq := wa.CreateRequest(http.MethodGet, "/")
+		if test.token != "" {
+			wreq.Header().Set("Authorization", "Bearer "+test.token)
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches(test.body)
+	}
+}
+
+// TestJWTHandlerWeakKeys tests the rejection of keys not matching
+// the algorithm requirements.
+func TestJWTHandlerWeakKeys(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("request passed"))
+		assert.NoError(err)
+	})
+	esKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	wa.Handle("/strict/hmac/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key: []byte("weak"),
+	}))
+	wa.Handle("/strict/ecdsa/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		KeyFunc: func(jwt *token.JWT) (token.Key, error) {
+			return esKey.Public(), nil
+		},
+	}))
+	wa.Handle("/weak/hmac/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key:           []byte("weak"),
+		AllowWeakKeys: true,
+	}))
+
+	tests := []struct {
+		path       string
+		key        token.Key
+		algorithm  token.Algorithm
+		statusCode int
+		body       string
+	}{
+		{"/strict/hmac/", []byte("weak"), token.HS256, http.StatusUnauthorized, "HMAC key for algorithm 'HS256' needs at least 32 bytes"},
+		{"/strict/ecdsa/", esKey, token.ES256, http.StatusOK, "request passed"},
+		{"/strict/ecdsa/", esKey, token.ES512, http.StatusUnauthorized, "ECDSA key curve does not match algorithm 'ES512'"},
+		{"/weak/hmac/", []byte("weak"), token.HS256, http.StatusOK, "request passed"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s / %s", i, test.path, test.algorithm)
+		jwt, err := token.Encode(token.NewClaims(), test.key, test.algorithm)
+		assert.NoError(err)
+		wreq := wa.CreateRequest(http.MethodGet, test.path)
+		wreq.Header().Set("Authorization", "Bearer "+jwt.String())
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches(test.body)
+	}
+}
+
+// TestJWTHandlerDPoP tests the checking of DPoP proofs and key bound
+// access tokens.
+func TestJWTHandlerDPoP(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("request passed"))
+		assert.NoError(err)
+	})
+	wa.Handle("/optional/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key:  []byte(secret),
+		DPoP: &web.DPoPConfig{},
+	}))
+	wa.Handle("/required/", web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key:  []byte(secret),
+		DPoP: &web.DPoPConfig{Required: true},
+	}))
+	key, err := token.GenerateKey(token.ES256)
+	assert.NoError(err)
+	prover, err := token.NewDPoPProver(key, token.ES256)
+	assert.NoError(err)
+	otherKey, err := token.GenerateKey(token.ES256)
+	assert.NoError(err)
+	other, err := token.NewDPoPProver(otherKey, token.ES256)
+	assert.NoError(err)
+	encode := func(jkt string) string {
+		claims := token.NewClaims()
+		if jkt != "" {
+			claims.SetConfirmation(token.Confirmation{JWKThumbprint: jkt})
+		}
+		jwt, err := token.Encode(claims, []byte(secret), token.HS512)
+		assert.NoError(err)
+		return jwt.String()
+	}
+	bound := encode(prover.Thumbprint())
+	unbound := encode("")
+
+	tests := []struct {
+		description string
+		path        string
+		scheme      string
+		accessToken string
+		prover      *token.DPoPProver
+		method      string
+		proofPath   string
+		statusCode  int
+		body        string
+	}{
+		{"valid proof", "/optional/", "DPoP", bound, prover, http.MethodGet, "/optional/", http.StatusOK, "request passed"},
+		{"required proof", "/required/", "DPoP", bound, prover, http.MethodGet, "/required/", http.StatusOK, "request passed"},
+		{"unbound bearer", "/optional/", "Bearer", unbound, nil, "", "", http.StatusOK, "request passed"},
+		{"bound bearer", "/optional/", "Bearer", bound, nil, "", "", http.StatusUnauthorized, "access token is bound to a DPoP key"},
+		{"bearer when required", "/required/", "Bearer", unbound, nil, "", "", http.StatusUnauthorized, "access token has to be bound by DPoP"},
+		{"missing proof", "/optional/", "DPoP", bound, nil, "", "", http.StatusUnauthorized, "needs exactly one DPoP proof"},
+		{"wrong key", "/optional/", "DPoP", bound, other, http.MethodGet, "/optional/", http.StatusUnauthorized, "not bound to the DPoP key"},
+		{"unbound token", "/optional/", "DPoP", unbound, prover, http.MethodGet, "/optional/", http.StatusUnauthorized, "not bound to the DPoP key"},
+		{"wrong method", "/optional/", "DPoP", bound, prover, http.MethodPost, "/optional/", http.StatusUnauthorized, "claim 'htm' does not match"},
+		{"wrong URL", "/optional/", "DPoP", bound, prover, http.MethodGet, "/required/", http.StatusUnauthorized, "claim 'htu' does not match"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.description)
+		wreq := wa.CreateRequest(http.MethodGet, test.path)
+		wreq.Header().Set("Authorization", test.scheme+" "+test.accessToken)
+		if test.prover != nil {
+			proof, err := test.prover.Proof(test.method, wa.URL()+test.proofPath, test.accessToken)
+			assert.NoError(err)
+			wreq.Header().Set("DPoP", proof)
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches(test.body)
+	}
+
+	assert.Logf("testing replayed proof")
+	proof, err := prover.Proof(http.MethodGet, wa.URL()+"/optional/", bound)
+	assert.NoError(err)
+	for i, body := range []string{"request passed", "DPoP proof has already been used"} {
+		assert.Logf("request #%d", i)
+		wreq := wa.CreateRequest(http.MethodGet, "/optional/")
+		wreq.Header().Set("Authorization", "DPoP "+bound)
+		wreq.Header().Set("DPoP", proof)
+		wresp := wreq.Do()
+		wresp.AssertBodyMatches(body)
+	}
+}
+
+// TestJWTHandlerDPoPProofIDs tests that only the identifiers of proofs
+// for valid and bound access tokens are kept and that their number is
+// limited.
+func TestJWTHandlerDPoPProofIDs(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	wa.Handle("/dpop/", web.NewJWTHandler(makeMethodEcho(assert), &web.JWTHandlerConfig{
+		Key:  []byte(secret),
+		DPoP: &web.DPoPConfig{MaxProofIDs: 2},
+	}))
+	key, err := token.GenerateKey(token.ES256)
+	assert.NoError(err)
+	prover, err := token.NewDPoPProver(key, token.ES256)
+	assert.NoError(err)
+	otherKey, err := token.GenerateKey(token.ES256)
+	assert.NoError(err)
+	other, err := token.NewDPoPProver(otherKey, token.ES256)
+	assert.NoError(err)
+	encode := func(key []byte) string {
+		claims := token.NewClaims()
+		claims.SetConfirmation(token.Confirmation{JWKThumbprint: prover.Thumbprint()})
+		jwt, err := token.Encode(claims, key, token.HS512)
+		assert.NoError(err)
+		return jwt.String()
+	}
+	bound := encode([]byte(secret))
+	invalid := encode([]byte(strings.Repeat("wrong", 13)))
+	request := func(accessToken, proof string) *environments.WebResponse {
+		wreq := wa.CreateRequest(http.MethodGet, "/dpop/")
+		wreq.Header().Set("Authorization", "DPoP "+accessToken)
+		wreq.Header().Set("DPoP", proof)
+		return wreq.Do()
+	}
+
+	assert.Logf("testing rejected proofs are not kept")
+	invalidProof, err := prover.Proof(http.MethodGet, wa.URL()+"/dpop/", invalid)
+	assert.NoError(err)
+	otherProof, err := other.Proof(http.MethodGet, wa.URL()+"/dpop/", bound)
+	assert.NoError(err)
+	for i := 0; i < 3; i++ {
+		wresp := request(invalid, invalidProof)
+		wresp.AssertStatusCodeEquals(http.StatusUnauthorized)
+		assert.False(strings.Contains(string(wresp.Body()), "already been used"))
+		wresp = request(bound, otherProof)
+		wresp.AssertStatusCodeEquals(http.StatusUnauthorized)
+		wresp.AssertBodyMatches("not bound to the DPoP key")
+	}
+
+	assert.Logf("testing limited number of kept proofs")
+	for i, statusCode := range []int{http.StatusOK, http.StatusOK, http.StatusServiceUnavailable} {
+		assert.Logf("request #%d", i)
+		proof, err := prover.Proof(http.MethodGet, wa.URL()+"/dpop/", bound)
+		assert.NoError(err)
+		wresp := request(bound, proof)
+		wresp.AssertStatusCodeEquals(statusCode)
+		if statusCode == http.StatusServiceUnavailable {
+			wresp.AssertBodyMatches("too many DPoP proofs in use")
+		}
+	}
+}
+
+// TestJWTHandlerCertificateBound tests the checking of access tokens
+// bound to client certificates of mutual TLS connections.
+func TestJWTHandlerCertificateBound(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte("request passed"))
+		assert.NoError(err)
+	})
+	server := httptest.NewUnstartedServer(web.NewJWTHandler(handler, &web.JWTHandlerConfig{
+		Key:              []byte(secret),
+		CertificateBound: true,
+	}))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+	clientCert, clientX509 := newClientCertificate(assert, "client")
+	otherCert, _ := newClientCertificate(assert, "other")
+	encode := func(x5t string) string {
+		claims := token.NewClaims()
+		if x5t != "" {
+			claims.SetConfirmation(token.Confirmation{X509Thumbprint: x5t})
+		}
+		jwt, err := token.Encode(claims, []byte(secret), token.HS512)
+		assert.NoError(err)
+		return jwt.String()
+	}
+	bound := encode(token.CertificateThumbprint(clientX509))
+	unbound := encode("")
+
+	tests := []struct {
+		description string
+		cert        *tls.Certificate
+		accessToken string
+		statusCode  int
+		body        string
+	}{
+		{"matching certificate", &clientCert, bound, http.StatusOK, "request passed"},
+		{"unbound token", &clientCert, unbound, http.StatusOK, "request passed"},
+		{"unbound token without certificate", nil, unbound, http.StatusOK, "request passed"},
+		{"other certificate", &otherCert, bound, http.StatusUnauthorized, "not bound to the client certificate"},
+		{"no certificate", nil, bound, http.StatusUnauthorized, "bound to a client certificate"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.description)
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if test.cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*test.cert}
+		}
+		client := &http.Client{Transport: transport}
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		assert.NoError(err)
+		req.Header.Set("Authorization", "Bearer "+test.accessToken)
+		resp, err := client.Do(req)
+		assert.NoError(err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(err)
+		resp.Body.Close()
+		assert.Equal(resp.StatusCode, test.statusCode)
+		assert.Substring(test.body, string(body))
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// secret is a HMAC secret long enough for all HMAC algorithms.
+const secret = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// newClientCertificate creates a self-signed client certificate.
+func newClientCertificate(assert *asserts.Asserts, cn string) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	assert.NoError(err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+// stubVerifier simulates the verification of opaque tokens.
+type stubVerifier struct{}
+
+func (v stubVerifier) VerifyToken(ctx context.Context, st string) (token.Claims, error) {
+	claims := token.NewClaims()
+	switch st {
+	case "opaque-john":
+		claims.SetSubject("john")
+	case "opaque-expired":
+		claims.SetSubject("jane")
+		claims.SetExpiration(time.Now().Add(-time.Hour))
+	default:
+		return nil, errors.New("token is not active")
+	}
+	return claims, nil
+}
+
 // EOF
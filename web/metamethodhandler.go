@@ -78,9 +78,16 @@ type TraceHandler interface {
 //--------------------
 
 // MetaMethodHandler checks if the handler contains a handler with
-// a matching interface for the HTTP method.
+// a matching interface for the HTTP method. Without HeadHandler the
+// GetHandler serves HEAD requests with the body discarded, without
+// OptionsHandler OPTIONS requests are answered with the methods of
+// the implemented interfaces in the Allow header. Requests for methods
+// without matching interface are rejected with status code 405 and
+// the Allow header like by the MethodHandler.
 type MetaMethodHandler struct {
-	handler http.Handler
+	handler  http.Handler
+	allow    string
+	renderer ErrorRenderer
 }
 
 // NewMetaMethodHandler creates a meta HTTP method handler.
@@ -90,9 +97,24 @@ func NewMetaMethodHandler(handler http.Handler) *MetaMethodHandler {
 	}
 	return &MetaMethodHandler{
 		handler: handler,
+		allow:   allowHeader(implementedMethods(handler)),
 	}
 }
 
+// MetaMethodMiddleware returns a middleware wrapping handlers with
+// a MetaMethodHandler.
+func MetaMethodMiddleware() Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewMetaMethodHandler(handler)
+	}
+}
+
+// SetErrorRenderer sets the renderer for requests with methods
+// without handler. Passing nil resets it to RenderProblem.
+func (mmh *MetaMethodHandler) SetErrorRenderer(er ErrorRenderer) {
+	mmh.renderer = er
+}
+
 // ServeHTTP implements http.Handler.
 func (mmh *MetaMethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -106,6 +128,10 @@ func (mmh *MetaMethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			h.ServeHTTPHead(w, r)
 			return
 		}
+		if h, ok := mmh.handler.(GetHandler); ok {
+			h.ServeHTTPGet(&headResponseWriter{w}, r)
+			return
+		}
 	case http.MethodPost:
 		if h, ok := mmh.handler.(PostHandler); ok {
 			h.ServeHTTPPost(w, r)
@@ -136,13 +162,54 @@ func (mmh *MetaMethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 			h.ServeHTTPOptions(w, r)
 			return
 		}
+		serveOptions(w, mmh.allow)
+		return
 	case http.MethodTrace:
 		if h, ok := mmh.handler.(TraceHandler); ok {
 			h.ServeHTTPTrace(w, r)
 			return
 		}
 	}
-	mmh.handler.ServeHTTP(w, r)
+	w.Header().Set("Allow", mmh.allow)
+	writeProblem(mmh.renderer, w, r, http.StatusMethodNotAllowed, "no matching method handler found")
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// implementedMethods returns the methods of the method handler
+// interfaces implemented by the handler.
+func implementedMethods(handler http.Handler) []string {
+	var methods []string
+	if _, ok := handler.(GetHandler); ok {
+		methods = append(methods, http.MethodGet)
+	}
+	if _, ok := handler.(HeadHandler); ok {
+		methods = append(methods, http.MethodHead)
+	}
+	if _, ok := handler.(PostHandler); ok {
+		methods = append(methods, http.MethodPost)
+	}
+	if _, ok := handler.(PutHandler); ok {
+		methods = append(methods, http.MethodPut)
+	}
+	if _, ok := handler.(PatchHandler); ok {
+		methods = append(methods, http.MethodPatch)
+	}
+	if _, ok := handler.(DeleteHandler); ok {
+		methods = append(methods, http.MethodDelete)
+	}
+	if _, ok := handler.(ConnectHandler); ok {
+		methods = append(methods, http.MethodConnect)
+	}
+	if _, ok := handler.(OptionsHandler); ok {
+		methods = append(methods, http.MethodOptions)
+	}
+	if _, ok := handler.(TraceHandler); ok {
+		methods = append(methods, http.MethodTrace)
+	}
+	return methods
 }
 
 // EOF
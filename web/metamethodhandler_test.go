@@ -50,40 +50,40 @@ func TestMethodWrapper(t *testing.T) {
 	}{
 		{
 			method:     http.MethodGet,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       "no matching method handler found",
 		}, {
 			method:     http.MethodHead,
-			statusCode: http.StatusBadRequest,
+			statusCode: http.StatusMethodNotAllowed,
 			body:       "",
 		}, {
 			method:     http.MethodPost,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       "no matching method handler found",
 		}, {
 			method:     http.MethodPut,
 			statusCode: http.StatusOK,
 			body:       "METHOD: PUT!",
 		}, {
 			method:     http.MethodPatch,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       "no matching method handler found",
 		}, {
 			method:     http.MethodDelete,
 			statusCode: http.StatusNoContent,
 			body:       "",
 		}, {
 			method:     http.MethodConnect,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       "no matching method handler found",
 		}, {
 			method:     http.MethodOptions,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusNoContent,
+			body:       "",
 		}, {
 			method:     http.MethodTrace,
-			statusCode: http.StatusBadRequest,
-			body:       "bad request",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       "no matching method handler found",
 		},
 	}
 	for i, test := range tests {
@@ -92,9 +92,37 @@ func TestMethodWrapper(t *testing.T) {
 		wresp := wreq.Do()
 		wresp.AssertStatusCodeEquals(test.statusCode)
 		wresp.AssertBodyMatches(test.body)
+		if test.statusCode != http.StatusOK && test.method != http.MethodDelete {
+			wresp.Header().AssertKeyValueEquals("Allow", "DELETE, OPTIONS, PUT")
+		}
 	}
 }
 
+// TestMetaMethodHandlerHead tests the serving of HEAD requests by
+// the GetHandler.
+func TestMetaMethodHandlerHead(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	wa.Handle("/mmh/", web.NewMetaMethodHandler(mmGetHandler{}))
+
+	wreq := wa.CreateRequest(http.MethodGet, "/mmh/")
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusOK)
+	wresp.AssertBodyMatches("METHOD: GET!")
+
+	wreq = wa.CreateRequest(http.MethodHead, "/mmh/")
+	wresp = wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusOK)
+	wresp.AssertBodyMatches("")
+
+	wreq = wa.CreateRequest(http.MethodOptions, "/mmh/")
+	wresp = wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusNoContent)
+	wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS")
+}
+
 //--------------------
 // HELPING HANDLER
 //--------------------
@@ -120,4 +148,17 @@ func (h mmHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "bad request", http.StatusBadRequest)
 }
 
+// mmGetHandler only provides the GET method for the MetaMethodHandler.
+type mmGetHandler struct{}
+
+func (h mmGetHandler) ServeHTTPGet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("METHOD: " + r.Method + "!"))
+}
+
+func (h mmGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
 // EOF
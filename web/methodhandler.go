@@ -12,9 +12,14 @@ package web // import "tideland.dev/go/net/web"
 //--------------------
 
 import (
+	"bufio"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 
 	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/trace/failure"
 )
 
 //--------------------
@@ -32,9 +37,13 @@ const (
 //--------------------
 
 // MethodHandler distributes request depending on the HTTP method
-// to subhandlers.
+// to subhandlers. HEAD requests are served by the GET handler with
+// the body discarded if no own handler is registered. OPTIONS requests
+// without handler are answered with the allowed methods in the Allow
+// header, same as the status code 405 for methods without handler.
 type MethodHandler struct {
 	handlers map[string]http.Handler
+	renderer ErrorRenderer
 }
 
 // NewMethodHandler creates an empty HTTP method handler.
@@ -66,17 +75,106 @@ func (mh *MethodHandler) HandleFunc(method string, hf func(http.ResponseWriter,
 	mh.Handle(method, http.HandlerFunc(hf))
 }
 
+// SetErrorRenderer sets the renderer for requests with methods
+// without handler. Passing nil resets it to RenderProblem.
+func (mh *MethodHandler) SetErrorRenderer(er ErrorRenderer) {
+	mh.renderer = er
+}
+
 // ServeHTTP implements http.Handler.
 func (mh *MethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	handler, ok := mh.handlers[r.Method]
-	if !ok {
-		handler, ok = mh.handlers[MethodAll]
-		if !ok {
-			http.Error(w, "no matching method handler found", http.StatusMethodNotAllowed)
+	serveMethod(w, r, mh.handlers, mh.renderer)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// serveMethod lets the handler for the method of the request serve
+// it. HEAD is served by GET, the handler for MethodAll serves all
+// other methods. Without it OPTIONS is answered automatically and
+// other methods are not allowed and rendered as problem.
+func serveMethod(w http.ResponseWriter, r *http.Request, handlers map[string]http.Handler, render ErrorRenderer) {
+	if handler, ok := handlers[r.Method]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	if r.Method == http.MethodHead {
+		if handler, ok := handlers[http.MethodGet]; ok {
+			handler.ServeHTTP(&headResponseWriter{w}, r)
 			return
 		}
 	}
-	handler.ServeHTTP(w, r)
+	if handler, ok := handlers[MethodAll]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	var methods []string
+	for method := range handlers {
+		methods = append(methods, method)
+	}
+	allow := allowHeader(methods)
+	if r.Method == http.MethodOptions {
+		serveOptions(w, allow)
+		return
+	}
+	w.Header().Set("Allow", allow)
+	writeProblem(render, w, r, http.StatusMethodNotAllowed, "no matching method handler found")
+}
+
+// allowHeader returns the value of the Allow header for the methods.
+// HEAD is added if GET is allowed, OPTIONS always.
+func allowHeader(methods []string) string {
+	allowed := map[string]bool{
+		http.MethodOptions: true,
+	}
+	for _, method := range methods {
+		allowed[method] = true
+		if method == http.MethodGet {
+			allowed[http.MethodHead] = true
+		}
+	}
+	delete(allowed, MethodAll)
+	sorted := make([]string, 0, len(allowed))
+	for method := range allowed {
+		sorted = append(sorted, method)
+	}
+	sort.Strings(sorted)
+	return strings.Join(sorted, ", ")
+}
+
+// serveOptions answers an OPTIONS request with the allowed methods.
+func serveOptions(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// headResponseWriter discards the body written by a GET handler
+// serving a HEAD request. Flushing and hijacking are passed to the
+// original writer if it supports them.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Write implements io.Writer.
+func (w *headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Flush implements http.Flusher.
+func (w *headResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker.
+func (w *headResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, failure.New("response writer does not support hijacking")
+	}
+	return h.Hijack()
 }
 
 // EOF
@@ -73,7 +73,7 @@ func TestMethodHandler(t *testing.T) {
 			body:       "METHOD: GET!",
 		}, {
 			method:     http.MethodHead,
-			statusCode: http.StatusMethodNotAllowed,
+			statusCode: http.StatusOK,
 			body:       "",
 		}, {
 			method:     http.MethodPost,
@@ -111,6 +111,58 @@ func TestMethodHandler(t *testing.T) {
 		wresp := wreq.Do()
 		wresp.AssertStatusCodeEquals(test.statusCode)
 		wresp.AssertBodyMatches(test.body)
+		if test.statusCode == http.StatusMethodNotAllowed {
+			wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS, PATCH")
+		}
+	}
+}
+
+// TestMethodHandlerOptions tests the automatic handling of OPTIONS
+// requests without registered handler.
+func TestMethodHandlerOptions(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	mh := web.NewMethodHandler()
+
+	mh.HandleFunc(http.MethodGet, makeMethodEcho(assert))
+	mh.HandleFunc(http.MethodPost, makeMethodEcho(assert))
+
+	wa.Handle("/mh/", mh)
+
+	wreq := wa.CreateRequest(http.MethodOptions, "/mh/")
+	wresp := wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusNoContent)
+	wresp.AssertBodyMatches("")
+	wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS, POST")
+
+	wreq = wa.CreateRequest(http.MethodDelete, "/mh/")
+	wresp = wreq.Do()
+	wresp.AssertStatusCodeEquals(http.StatusMethodNotAllowed)
+	wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS, POST")
+}
+
+// TestMethodHandlerHeadStreaming tests that GET handlers serving
+// HEAD requests can flush like for GET requests.
+func TestMethodHandlerHeadStreaming(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	mh := web.NewMethodHandler()
+	mh.HandleFunc(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		assert.True(ok)
+		_, _ = w.Write([]byte("data: ping\n\n"))
+		f.Flush()
+	})
+	wa.Handle("/mh/", mh)
+
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		assert.Logf("testing %s", method)
+		wresp := wa.CreateRequest(method, "/mh/").Do()
+		wresp.AssertStatusCodeEquals(http.StatusOK)
 	}
 }
 
@@ -12,6 +12,7 @@ package web // import "tideland.dev/go/net/web"
 //--------------------
 
 import (
+	"context"
 	"net/http"
 	"strings"
 )
@@ -20,12 +21,31 @@ import (
 // NESTED HANDLER
 //--------------------
 
+// EntityIDsFromContext returns the entity IDs of the path handled by
+// a NestedHandler, if any. They are named by the IDs of their handlers.
+func EntityIDsFromContext(ctx context.Context) (Params, bool) {
+	ids, ok := ctx.Value(entityIDsKey).(Params)
+	return ids, ok
+}
+
+// EntityID returns the entity ID following the handler ID in the
+// request path handled by a NestedHandler and true if it exists.
+// Otherwise an empty string and false.
+func EntityID(r *http.Request, handlerID string) (string, bool) {
+	ids, _ := EntityIDsFromContext(r.Context())
+	return ids.Get(handlerID)
+}
+
 // NestedHandler allows to nest handler following the
 // pattern /handlerA/{entityID-A}/handlerB/{entityID-B}.
+// The handler IDs have to match the path, the entity IDs
+// are stored in the request context, e.g. for the path
+// /orders/4711/items/1 orders is 4711 and items is 1.
 type NestedHandler struct {
 	handlerIDs  []string
 	handlers    []http.Handler
 	handlersLen int
+	renderer    ErrorRenderer
 }
 
 // NewNestedHandler creates an empty nested handler.
@@ -35,6 +55,17 @@ func NewNestedHandler() *NestedHandler {
 
 // AppendHandler adds one handler to the stack of handlers.
 func (nh *NestedHandler) AppendHandler(id string, h http.Handler) {
+	if id == "" || strings.Contains(id, "/") {
+		panic("invalid handler ID")
+	}
+	if h == nil {
+		panic("need handler")
+	}
+	for _, handlerID := range nh.handlerIDs {
+		if handlerID == id {
+			panic("multiple registrations for " + id)
+		}
+	}
 	nh.handlerIDs = append(nh.handlerIDs, id)
 	nh.handlers = append(nh.handlers, h)
 	nh.handlersLen++
@@ -42,25 +73,49 @@ func (nh *NestedHandler) AppendHandler(id string, h http.Handler) {
 
 // AppendHandlerFunc adds one handler function to the stack of handlers.
 func (nh *NestedHandler) AppendHandlerFunc(id string, hf func(http.ResponseWriter, *http.Request)) {
+	if hf == nil {
+		panic("need handler function")
+	}
 	nh.AppendHandler(id, http.HandlerFunc(hf))
 }
 
+// SetErrorRenderer sets the renderer for requests with paths not
+// matching the handlers. Passing nil resets it to RenderProblem.
+func (nh *NestedHandler) SetErrorRenderer(er ErrorRenderer) {
+	nh.renderer = er
+}
+
 // ServeHTTP implements http.Handler.
 func (nh *NestedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	handler := nh.handler(r.URL.Path)
+	handler, ids := nh.handler(r.URL.Path)
+	if len(ids) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), entityIDsKey, ids))
+	}
 	handler.ServeHTTP(w, r)
 }
 
-// handler retrieves the correct handler from the stack.
-func (nh *NestedHandler) handler(path string) http.Handler {
+// handler retrieves the correct handler from the stack and
+// the entity IDs of the path.
+func (nh *NestedHandler) handler(path string) (http.Handler, Params) {
 	path = strings.Trim(path, "/")
 	fields := strings.Split(path, "/")
 	fieldsLen := len(fields)
 	index := (fieldsLen - 1) / 2
 	if (fieldsLen == 1 && fields[0] == "") || index >= nh.handlersLen {
-		return http.NotFoundHandler()
+		return notFound(nh.renderer), nil
+	}
+	var ids Params
+	for i, field := range fields {
+		switch {
+		case field == "":
+			return notFound(nh.renderer), nil
+		case i%2 == 0 && field != nh.handlerIDs[i/2]:
+			return notFound(nh.renderer), nil
+		case i%2 == 1:
+			ids = append(ids, Param{nh.handlerIDs[i/2], field})
+		}
 	}
-	return nh.handlers[index]
+	return nh.handlers[index], ids
 }
 
 // EOF
@@ -121,4 +121,77 @@ func TestNestedHandler(t *testing.T) {
 	}
 }
 
+// TestNestedHandlerEntityIDs tests the matching of handler IDs
+// and the access to entity IDs.
+func TestNestedHandlerEntityIDs(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	nh := web.NewNestedHandler()
+	makeIDEcho := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			reply := name
+			ids, _ := web.EntityIDsFromContext(r.Context())
+			for _, id := range ids {
+				value, ok := web.EntityID(r, id.Name)
+				assert.True(ok)
+				reply += " " + id.Name + "=" + value
+			}
+			w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+			w.WriteHeader(http.StatusOK)
+			_, err := w.Write([]byte(reply))
+			assert.NoError(err)
+		}
+	}
+	nh.AppendHandlerFunc("orders", makeIDEcho("orders"))
+	nh.AppendHandlerFunc("items", makeIDEcho("items"))
+
+	wa.Handle("/orders/", nh)
+	wa.Handle("/foo/", nh)
+
+	tests := []struct {
+		path       string
+		statusCode int
+		body       string
+	}{
+		{"/orders", http.StatusOK, "orders"},
+		{"/orders/4711", http.StatusOK, "orders orders=4711"},
+		{"/orders/4711/items", http.StatusOK, "items orders=4711"},
+		{"/orders/4711/items/1", http.StatusOK, "items orders=4711 items=1"},
+		{"/orders/4711/bar/1", http.StatusNotFound, "no matching path handler found"},
+		{"/orders//items/1", http.StatusNotFound, "no matching path handler found"},
+		{"/foo/4711/items/1", http.StatusNotFound, "no matching path handler found"},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: %s", i, test.path)
+		wreq := wa.CreateRequest(http.MethodGet, test.path)
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches(test.body)
+	}
+}
+
+// TestInvalidNestedHandler tests the panics for invalid values
+// passed to a NestedHandler.
+func TestInvalidNestedHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	nh := web.NewNestedHandler()
+	echo := func(w http.ResponseWriter, r *http.Request) {}
+
+	assert.Panics(func() {
+		nh.AppendHandlerFunc("", echo)
+	}, "invalid handler ID")
+	assert.Panics(func() {
+		nh.AppendHandlerFunc("orders/items", echo)
+	}, "invalid handler ID")
+	assert.Panics(func() {
+		nh.AppendHandlerFunc("orders", nil)
+	}, "need handler function")
+	nh.AppendHandlerFunc("orders", echo)
+	assert.Panics(func() {
+		nh.AppendHandlerFunc("orders", echo)
+	}, "multiple registrations for orders")
+}
+
 // EOF
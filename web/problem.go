@@ -0,0 +1,62 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// ERROR RENDERING
+//--------------------
+
+// ErrorRenderer writes the problem details of errors detected by the
+// handlers of this package, e.g. unknown paths, unsupported methods,
+// or invalid tokens, as response. It is configured per handler, the
+// default is RenderProblem.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, p *httpx.Problem)
+
+// RenderProblem is the default error renderer. It writes the problem
+// as JSON or XML depending on the request like httpx.WriteProblem.
+func RenderProblem(w http.ResponseWriter, r *http.Request, p *httpx.Problem) {
+	if err := httpx.WriteProblem(w, r, p); err != nil {
+		logger.Errorf("web: cannot write problem: %v", err)
+	}
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// writeProblem renders the problem with status code and detail for
+// the path of the request. A nil renderer stands for RenderProblem.
+func writeProblem(render ErrorRenderer, w http.ResponseWriter, r *http.Request, statusCode int, detail string) {
+	if render == nil {
+		render = RenderProblem
+	}
+	p := httpx.NewProblem(statusCode, detail)
+	p.Instance = r.URL.Path
+	render(w, r, p)
+}
+
+// notFound returns a handler rendering the problem for requests
+// without matching handler for their path.
+func notFound(render ErrorRenderer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeProblem(render, w, r, http.StatusNotFound, "no matching path handler found")
+	})
+}
+
+// EOF
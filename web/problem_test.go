@@ -0,0 +1,174 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestProblems tests the problem details written by the handlers.
+func TestProblems(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	mh := web.NewMethodHandler()
+	mh.HandleFunc(http.MethodGet, makeMethodEcho(assert))
+	nh := web.NewNestedHandler()
+	nh.AppendHandler("orders", mh)
+	rt := web.NewRouter()
+	rt.Handle(http.MethodGet, "/router/orders", mh)
+
+	wa.Handle("/method/", mh)
+	wa.Handle("/jwt/", web.NewJWTHandler(mh, nil))
+	wa.Handle("/router/", rt)
+	wa.Handle("/", nh)
+
+	tests := []struct {
+		method     string
+		path       string
+		accept     string
+		statusCode int
+		body       string
+	}{
+		{
+			method:     http.MethodPost,
+			path:       "/method/",
+			statusCode: http.StatusMethodNotAllowed,
+			body:       `{"detail":"no matching method handler found","instance":"/method/","status":405,"title":"Method Not Allowed"}`,
+		}, {
+			method:     http.MethodGet,
+			path:       "/router/unknown",
+			statusCode: http.StatusNotFound,
+			body:       `{"detail":"no matching path handler found","instance":"/router/unknown","status":404,"title":"Not Found"}`,
+		}, {
+			method:     http.MethodGet,
+			path:       "/customers/1",
+			accept:     httpx.ContentTypeProblemXML,
+			statusCode: http.StatusNotFound,
+			body: `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				`<problem xmlns="urn:ietf:rfc:7807"><title>Not Found</title><status>404</status>` +
+				`<detail>no matching path handler found</detail><instance>/customers/1</instance></problem>`,
+		},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s %s", test.method, test.path)
+		wreq := wa.CreateRequest(test.method, test.path)
+		if test.accept != "" {
+			wreq.Header().Set(httpx.HeaderAccept, test.accept)
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		if test.accept == "" {
+			wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, httpx.ContentTypeProblemJSON)
+		} else {
+			wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, httpx.ContentTypeProblemXML)
+		}
+		assert.Equal(string(wresp.Body()), test.body)
+	}
+
+	wresp := wa.CreateRequest(http.MethodGet, "/jwt/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusUnauthorized)
+	wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, httpx.ContentTypeProblemJSON)
+	wresp.AssertBodyMatches(`^{"detail":".*request contains no authorization header","instance":"/jwt/","status":401,"title":"Unauthorized"}$`)
+}
+
+// TestErrorRenderer tests the usage of the error renderers configured
+// per handler.
+func TestErrorRenderer(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	extended := func(w http.ResponseWriter, r *http.Request, p *httpx.Problem) {
+		p.Type = "https://example.com/problems/" + r.Method
+		p.Extensions = map[string]interface{}{"traceID": "4711"}
+		web.RenderProblem(w, r, p)
+	}
+	plain := func(w http.ResponseWriter, r *http.Request, p *httpx.Problem) {
+		http.Error(w, p.Error(), p.Status)
+	}
+
+	mh := web.NewMethodHandler()
+	mh.SetErrorRenderer(extended)
+	rt := web.NewRouter()
+	rt.SetErrorRenderer(plain)
+	nh := web.NewNestedHandler()
+	nh.SetErrorRenderer(plain)
+	nh.AppendHandler("orders", mh)
+
+	wa.Handle("/method/", mh)
+	wa.Handle("/default/", web.NewMethodHandler())
+	wa.Handle("/router/", rt)
+	wa.Handle("/nested/", http.StripPrefix("/nested", nh))
+	wa.Handle("/jwt/", web.NewJWTHandler(mh, &web.JWTHandlerConfig{
+		ErrorRenderer: plain,
+	}))
+	wa.Handle("/recovery/", web.NewRecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("ouch")
+	}), &web.RecoveryHandlerConfig{
+		ErrorRenderer: plain,
+	}))
+	wa.Handle("/ratelimit/", web.NewRateLimitHandler(http.HandlerFunc(makeMethodEcho(assert)), &web.RateLimitConfig{
+		Limit:         1,
+		Window:        time.Minute,
+		Store:         web.NewRateLimitStore(ctx, time.Minute, 1000),
+		ErrorRenderer: plain,
+	}))
+
+	wresp := wa.CreateRequest(http.MethodDelete, "/method/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusMethodNotAllowed)
+	assert.Equal(string(wresp.Body()), `{"detail":"no matching method handler found","instance":"/method/",`+
+		`"status":405,"title":"Method Not Allowed","traceID":"4711","type":"https://example.com/problems/DELETE"}`)
+
+	wresp = wa.CreateRequest(http.MethodDelete, "/default/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusMethodNotAllowed)
+	wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, httpx.ContentTypeProblemJSON)
+
+	tests := []struct {
+		path       string
+		statusCode int
+		body       string
+	}{
+		{"/router/unknown", http.StatusNotFound, "404 Not Found: no matching path handler found"},
+		{"/nested/customers", http.StatusNotFound, "404 Not Found: no matching path handler found"},
+		{"/jwt/", http.StatusUnauthorized, "401 Unauthorized: .*no authorization header"},
+		{"/recovery/", http.StatusInternalServerError, "500 Internal Server Error"},
+		{"/ratelimit/", http.StatusOK, ".*"},
+		{"/ratelimit/", http.StatusTooManyRequests, "429 Too Many Requests: rate limit exceeded"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s", test.path)
+		wresp = wa.CreateRequest(http.MethodGet, test.path).Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches("^" + test.body + "\n?$")
+	}
+}
+
+// EOF
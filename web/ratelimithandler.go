@@ -0,0 +1,279 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// RATE LIMIT KEYS
+//--------------------
+
+// RateLimitKeyFunc returns the key of a request whose rate is
+// limited, e.g. the client IP. Requests with an empty key are not
+// limited.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// ClientIPKey returns the IP of the client as key. Proxy headers are
+// not taken into account, so the IP is the one of the connection.
+func ClientIPKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SubjectKey returns the subject of the JSON Web Token as key if a
+// JWTHandler has authenticated the request before. Otherwise the
+// IP of the client is used.
+func SubjectKey(r *http.Request) string {
+	if claims, ok := ClaimsFromContext(r.Context()); ok {
+		if subject, ok := claims.Subject(); ok && subject != "" {
+			return "sub:" + subject
+		}
+	}
+	return "ip:" + ClientIPKey(r)
+}
+
+//--------------------
+// RATE LIMIT HANDLER
+//--------------------
+
+// RateLimitAlgorithm defines how request rates are limited.
+type RateLimitAlgorithm int
+
+// Algorithms for the limiting of request rates. TokenBucket refills
+// a bucket of Burst tokens with Limit tokens per Window and allows
+// bursts. SlidingWindow allows Limit requests in any Window, it
+// estimates the count out of the current and the previous window.
+const (
+	TokenBucket RateLimitAlgorithm = iota
+	SlidingWindow
+)
+
+// RateLimitConfig allows to control how the rate limit handler
+// works. Store, Limit, and Window are needed, all other values are
+// optional. In this case a token bucket with a burst of Limit tokens
+// is used for each client IP. The Store keeps the states and runs
+// as long as the context passed at its creation, so its lifetime is
+// controlled by the caller. The ErrorRenderer writes the problem details of
+// rejected requests, default is RenderProblem.
+type RateLimitConfig struct {
+	Store         *RateLimitStore
+	Algorithm     RateLimitAlgorithm
+	Limit         int
+	Window        time.Duration
+	Burst         int
+	KeyFunc       RateLimitKeyFunc
+	ErrorRenderer ErrorRenderer
+}
+
+// RateLimitHandler limits the rate of requests per key passed to the
+// wrapped handler. The state of the limit is returned in the headers
+// RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset. Requests
+// exceeding the limit are rejected with the status code 429 and the
+// Retry-After header.
+type RateLimitHandler struct {
+	handler   http.Handler
+	store     *RateLimitStore
+	algorithm RateLimitAlgorithm
+	limit     int
+	window    time.Duration
+	burst     int
+	rate      float64
+	keyFunc   RateLimitKeyFunc
+	renderer  ErrorRenderer
+}
+
+// NewRateLimitHandler creates a handler limiting the rate of requests
+// to the given handler.
+func NewRateLimitHandler(handler http.Handler, config *RateLimitConfig) *RateLimitHandler {
+	if handler == nil {
+		panic("need handler")
+	}
+	if config == nil {
+		panic("need rate limit configuration")
+	}
+	if config.Limit <= 0 || config.Window <= 0 {
+		panic("rate limit and window have to be positive")
+	}
+	if config.Store == nil {
+		panic("need rate limit store")
+	}
+	rh := &RateLimitHandler{
+		handler:   handler,
+		store:     config.Store,
+		algorithm: config.Algorithm,
+		limit:     config.Limit,
+		window:    config.Window,
+		burst:     config.Burst,
+		rate:      float64(config.Limit) / float64(config.Window),
+		keyFunc:   config.KeyFunc,
+		renderer:  config.ErrorRenderer,
+	}
+	if rh.burst <= 0 {
+		rh.burst = rh.limit
+	}
+	if rh.keyFunc == nil {
+		rh.keyFunc = ClientIPKey
+	}
+	return rh
+}
+
+// RateLimitMiddleware returns a middleware wrapping handlers with a
+// RateLimitHandler using the given configuration.
+func RateLimitMiddleware(config *RateLimitConfig) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewRateLimitHandler(handler, config)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface. If the store fails
+// the request is passed anyway.
+func (rh *RateLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := rh.keyFunc(r)
+	if key == "" {
+		rh.handler.ServeHTTP(w, r)
+		return
+	}
+	result, err := rh.store.take(rh, key)
+	if err != nil {
+		logger.Errorf("rate limit handler: %v", err)
+		rh.handler.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.reset)))
+	if !result.allowed {
+		retryAfter := seconds(result.retryAfter)
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeProblem(rh.renderer, w, r, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	rh.handler.ServeHTTP(w, r)
+}
+
+// take takes one request for the entry at the given time.
+func (rh *RateLimitHandler) take(entry *rateLimitEntry, now time.Time) rateLimitResult {
+	if rh.algorithm == SlidingWindow {
+		return rh.takeWindow(entry, now)
+	}
+	return rh.takeToken(entry, now)
+}
+
+// takeToken takes a token out of the bucket after refilling it.
+func (rh *RateLimitHandler) takeToken(entry *rateLimitEntry, now time.Time) rateLimitResult {
+	capacity := float64(rh.burst)
+	if entry.updated.IsZero() {
+		entry.tokens = capacity
+	} else {
+		entry.tokens = math.Min(capacity, entry.tokens+float64(now.Sub(entry.updated))*rh.rate)
+	}
+	entry.updated = now
+	result := rateLimitResult{
+		limit: rh.burst,
+	}
+	if entry.tokens >= 1 {
+		entry.tokens--
+		result.allowed = true
+	} else {
+		result.retryAfter = time.Duration((1 - entry.tokens) / rh.rate)
+	}
+	result.remaining = int(entry.tokens)
+	result.reset = time.Duration((capacity - entry.tokens) / rh.rate)
+	entry.expires = now.Add(result.reset)
+	return result
+}
+
+// takeWindow counts the request in the current window if the
+// estimated count allows it.
+func (rh *RateLimitHandler) takeWindow(entry *rateLimitEntry, now time.Time) rateLimitResult {
+	if entry.start.IsZero() {
+		entry.start = now
+	}
+	if elapsed := now.Sub(entry.start); elapsed >= rh.window {
+		windows := elapsed / rh.window
+		if windows == 1 {
+			entry.previous = entry.current
+		} else {
+			entry.previous = 0
+		}
+		entry.current = 0
+		entry.start = entry.start.Add(windows * rh.window)
+	}
+	elapsed := now.Sub(entry.start)
+	weight := 1 - float64(elapsed)/float64(rh.window)
+	count := float64(entry.previous)*weight + float64(entry.current)
+	result := rateLimitResult{
+		limit: rh.limit,
+	}
+	if count+1 <= float64(rh.limit) {
+		entry.current++
+		count++
+		result.allowed = true
+	} else {
+		result.retryAfter = rh.windowRetryAfter(entry, elapsed)
+	}
+	result.remaining = rh.limit - int(math.Ceil(count))
+	if result.remaining < 0 {
+		result.remaining = 0
+	}
+	result.reset = entry.start.Add(rh.window).Sub(now)
+	entry.expires = entry.start.Add(2 * rh.window)
+	return result
+}
+
+// windowRetryAfter returns the duration until the estimated count
+// allows the next request.
+func (rh *RateLimitHandler) windowRetryAfter(entry *rateLimitEntry, elapsed time.Duration) time.Duration {
+	free := float64(rh.limit - entry.current - 1)
+	if free >= 0 {
+		// The previous window has to fade out.
+		fade := time.Duration(float64(rh.window) * (1 - free/float64(entry.previous)))
+		return fade - elapsed
+	}
+	// The current window has to end and fade out.
+	fade := time.Duration(float64(rh.window) * (1 - float64(rh.limit-1)/float64(entry.current)))
+	return rh.window - elapsed + fade
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// rateLimitResult is the result of taking a request.
+type rateLimitResult struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// seconds returns the duration in rounded up seconds.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// EOF
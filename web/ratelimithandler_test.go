@@ -0,0 +1,326 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/net/jwt/token"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidRateLimitHandler tests the panics for invalid values
+// passed to the RateLimitHandler.
+func TestInvalidRateLimitHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	echo := makeMethodEcho(assert)
+
+	assert.Panics(func() {
+		web.NewRateLimitHandler(nil, &web.RateLimitConfig{Limit: 1, Window: time.Second})
+	}, "need handler")
+	assert.Panics(func() {
+		web.NewRateLimitHandler(echo, nil)
+	}, "need rate limit configuration")
+	assert.Panics(func() {
+		web.NewRateLimitHandler(echo, &web.RateLimitConfig{Window: time.Second})
+	}, "rate limit and window have to be positive")
+	assert.Panics(func() {
+		web.NewRateLimitHandler(echo, &web.RateLimitConfig{Limit: 1})
+	}, "rate limit and window have to be positive")
+	assert.Panics(func() {
+		web.NewRateLimitHandler(echo, &web.RateLimitConfig{Limit: 1, Window: time.Second})
+	}, "need rate limit store")
+	assert.Panics(func() {
+		web.NewRateLimitStore(context.Background(), time.Minute, 0)
+	}, "maximum number of entries has to be positive")
+}
+
+// TestRateLimitTokenBucket tests the limiting with token buckets.
+func TestRateLimitTokenBucket(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	store := web.NewRateLimitStore(ctx, time.Minute, 1000)
+	wa.Handle("/bucket/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:   store,
+		Limit:   2,
+		Window:  time.Hour,
+		Burst:   3,
+		KeyFunc: headerKey,
+	}))
+	wa.Handle("/fast/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:  store,
+		Limit:  10,
+		Window: time.Second,
+	}))
+
+	tests := []struct {
+		client     string
+		statusCode int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{"a", http.StatusOK, "2", "1800", ""},
+		{"a", http.StatusOK, "1", "3600", ""},
+		{"b", http.StatusOK, "2", "1800", ""},
+		{"a", http.StatusOK, "0", "5400", ""},
+		{"a", http.StatusTooManyRequests, "0", "5400", "1800"},
+		{"b", http.StatusOK, "1", "3600", ""},
+		{"", http.StatusOK, "", "", ""},
+	}
+	for i, test := range tests {
+		assert.Logf("test case #%d: client %q", i, test.client)
+		wreq := wa.CreateRequest(http.MethodGet, "/bucket/")
+		wreq.Header().Set("X-Client", test.client)
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		if test.client != "" {
+			wresp.Header().AssertKeyValueEquals("Ratelimit-Limit", "3")
+		}
+		assertHeaderEquals(assert, wresp, "Ratelimit-Remaining", test.remaining)
+		assertHeaderEquals(assert, wresp, "Ratelimit-Reset", test.reset)
+		assertHeaderEquals(assert, wresp, "Retry-After", test.retryAfter)
+		if test.statusCode == http.StatusTooManyRequests {
+			wresp.AssertBodyMatches(`"detail":"rate limit exceeded"`)
+		}
+	}
+
+	// Tokens are refilled over time.
+	for i := 0; i < 10; i++ {
+		wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusOK)
+	}
+	wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusTooManyRequests)
+	time.Sleep(150 * time.Millisecond)
+	wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusOK)
+}
+
+// TestRateLimitSlidingWindow tests the limiting with sliding windows.
+func TestRateLimitSlidingWindow(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	store := web.NewRateLimitStore(ctx, time.Minute, 1000)
+	wa.Handle("/window/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:     store,
+		Algorithm: web.SlidingWindow,
+		Limit:     3,
+		Window:    time.Hour,
+	}))
+	wa.Handle("/fast/", web.RateLimitMiddleware(&web.RateLimitConfig{
+		Store:     store,
+		Algorithm: web.SlidingWindow,
+		Limit:     2,
+		Window:    100 * time.Millisecond,
+	})(makeMethodEcho(assert)))
+
+	for i, remaining := range []string{"2", "1", "0"} {
+		assert.Logf("request #%d", i)
+		wresp := wa.CreateRequest(http.MethodGet, "/window/").Do()
+		wresp.AssertStatusCodeEquals(http.StatusOK)
+		wresp.AssertBodyMatches("METHOD: GET!")
+		wresp.Header().AssertKeyValueEquals("Ratelimit-Limit", "3")
+		wresp.Header().AssertKeyValueEquals("Ratelimit-Remaining", remaining)
+		wresp.Header().AssertKeyValueEquals("Ratelimit-Reset", "3600")
+	}
+	wresp := wa.CreateRequest(http.MethodGet, "/window/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusTooManyRequests)
+	wresp.Header().AssertKeyValueEquals("Ratelimit-Remaining", "0")
+	wresp.Header().AssertKeyValueEquals("Retry-After", "4800")
+
+	// The count of the previous window fades out.
+	wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusOK)
+	wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusOK)
+	wresp = wa.CreateRequest(http.MethodGet, "/fast/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusTooManyRequests)
+	wresp.Header().AssertKeyValueEquals("Retry-After", "1")
+	time.Sleep(250 * time.Millisecond)
+	wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusOK)
+	wa.CreateRequest(http.MethodGet, "/fast/").Do().AssertStatusCodeEquals(http.StatusOK)
+}
+
+// TestRateLimitSubjectKey tests the limiting per JWT subject.
+func TestRateLimitSubjectKey(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	key := []byte(strings.Repeat("secret", 11))
+	wa.Handle("/subject/", web.NewChain(
+		web.JWTMiddleware(&web.JWTHandlerConfig{
+			Key:      key,
+			Optional: true,
+		}),
+		web.RateLimitMiddleware(&web.RateLimitConfig{
+			Store:   web.NewRateLimitStore(ctx, time.Minute, 1000),
+			Limit:   1,
+			Window:  time.Hour,
+			KeyFunc: web.SubjectKey,
+		}),
+	).ThenFunc(makeMethodEcho(assert)))
+
+	request := func(subject string) *environments.WebResponse {
+		wreq := wa.CreateRequest(http.MethodGet, "/subject/")
+		if subject != "" {
+			claims := token.NewClaims()
+			claims.SetSubject(subject)
+			jwt, err := token.Encode(claims, key, token.HS512)
+			assert.NoError(err)
+			wreq.Header().Set("Authorization", "Bearer "+jwt.String())
+		}
+		return wreq.Do()
+	}
+
+	request("john").AssertStatusCodeEquals(http.StatusOK)
+	request("jane").AssertStatusCodeEquals(http.StatusOK)
+	request("").AssertStatusCodeEquals(http.StatusOK)
+	request("john").AssertStatusCodeEquals(http.StatusTooManyRequests)
+	request("").AssertStatusCodeEquals(http.StatusTooManyRequests)
+}
+
+// TestRateLimitStore tests the cleanup and stopping of the store.
+func TestRateLimitStore(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	store := web.NewRateLimitStore(ctx, time.Hour, 1000)
+	wa.Handle("/bucket/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:   store,
+		Limit:   1,
+		Window:  100 * time.Millisecond,
+		KeyFunc: headerKey,
+	}))
+	wa.Handle("/window/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:     store,
+		Algorithm: web.SlidingWindow,
+		Limit:     1,
+		Window:    100 * time.Millisecond,
+		KeyFunc:   headerKey,
+	}))
+
+	for _, path := range []string{"/bucket/", "/window/"} {
+		for _, client := range []string{"a", "b"} {
+			wreq := wa.CreateRequest(http.MethodGet, path)
+			wreq.Header().Set("X-Client", client)
+			wreq.Do().AssertStatusCodeEquals(http.StatusOK)
+		}
+	}
+	l, err := store.Len()
+	assert.NoError(err)
+	assert.Equal(l, 4)
+
+	// Token buckets are full again after one window, sliding
+	// windows are empty after two.
+	time.Sleep(120 * time.Millisecond)
+	assert.NoError(store.Cleanup())
+	l, err = store.Len()
+	assert.NoError(err)
+	assert.Equal(l, 2)
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(store.Cleanup())
+	l, err = store.Len()
+	assert.NoError(err)
+	assert.Equal(l, 0)
+
+	// Requests pass if the store is stopped.
+	cancel()
+	_, err = store.Len()
+	assert.ErrorMatch(err, ".*rate limit store is stopped.*")
+	for i := 0; i < 3; i++ {
+		wreq := wa.CreateRequest(http.MethodGet, "/bucket/")
+		wreq.Header().Set("X-Client", "a")
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(http.StatusOK)
+		assertHeaderEquals(assert, wresp, "Ratelimit-Limit", "")
+	}
+	assert.Equal(tw.Len(), 3)
+}
+
+// TestRateLimitStoreMaxEntries tests the early removal of entries
+// when the store is full.
+func TestRateLimitStoreMaxEntries(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	store := web.NewRateLimitStore(ctx, time.Hour, 2)
+	wa.Handle("/short/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:   store,
+		Limit:   1,
+		Window:  time.Second,
+		KeyFunc: headerKey,
+	}))
+	wa.Handle("/long/", web.NewRateLimitHandler(makeMethodEcho(assert), &web.RateLimitConfig{
+		Store:   store,
+		Limit:   1,
+		Window:  time.Hour,
+		KeyFunc: headerKey,
+	}))
+	request := func(path, client string) *environments.WebResponse {
+		wreq := wa.CreateRequest(http.MethodGet, path)
+		wreq.Header().Set("X-Client", client)
+		return wreq.Do()
+	}
+
+	request("/short/", "a").AssertStatusCodeEquals(http.StatusOK)
+	request("/long/", "b").AssertStatusCodeEquals(http.StatusOK)
+	l, err := store.Len()
+	assert.NoError(err)
+	assert.Equal(l, 2)
+
+	// The new key removes the entry expiring next.
+	request("/long/", "c").AssertStatusCodeEquals(http.StatusOK)
+	l, err = store.Len()
+	assert.NoError(err)
+	assert.Equal(l, 2)
+	request("/long/", "b").AssertStatusCodeEquals(http.StatusTooManyRequests)
+	request("/long/", "c").AssertStatusCodeEquals(http.StatusTooManyRequests)
+	request("/short/", "a").AssertStatusCodeEquals(http.StatusOK)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// headerKey returns the client header as rate limit key.
+func headerKey(r *http.Request) string {
+	return r.Header.Get("X-Client")
+}
+
+// EOF
@@ -0,0 +1,191 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"time"
+
+	"tideland.dev/go/trace/failure"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// RATE LIMIT ENTRY
+//--------------------
+
+// rateLimitKey identifies the entry of a key for a limiter.
+type rateLimitKey struct {
+	limiter *RateLimitHandler
+	key     string
+}
+
+// rateLimitEntry contains the state of a key. Token buckets use
+// tokens and updated, sliding windows start, current, and previous.
+// The entry can be removed when it expired.
+type rateLimitEntry struct {
+	tokens   float64
+	updated  time.Time
+	start    time.Time
+	current  int
+	previous int
+	expires  time.Time
+}
+
+//--------------------
+// RATE LIMIT STORE
+//--------------------
+
+// defaultTimeout is the default timeout for synchronous actions.
+const defaultTimeout = 5 * time.Second
+
+// RateLimitStore keeps the state of the keys of rate limit handlers
+// in memory. Multiple handlers may share one store.
+type RateLimitStore struct {
+	ctx        context.Context
+	entries    map[rateLimitKey]*rateLimitEntry
+	interval   time.Duration
+	maxEntries int
+	actionc    chan func()
+}
+
+// NewRateLimitStore creates a new store for rate limits. The duration
+// of the interval controls how often the background cleanup of
+// expired entries is running. It stops when the context is done.
+// Final configuration parameter is the maximum number of entries
+// inside the store. If it is reached by a new key the entries
+// expiring next are removed early, so that their keys start with a
+// full limit again.
+func NewRateLimitStore(ctx context.Context, interval time.Duration, maxEntries int) *RateLimitStore {
+	if maxEntries <= 0 {
+		panic("maximum number of entries has to be positive")
+	}
+	s := &RateLimitStore{
+		ctx:        ctx,
+		entries:    map[rateLimitKey]*rateLimitEntry{},
+		interval:   interval,
+		maxEntries: maxEntries,
+		actionc:    make(chan func(), 1),
+	}
+	go s.backend()
+	return s
+}
+
+// Len returns the number of entries in the store.
+func (s *RateLimitStore) Len() (int, error) {
+	var l int
+	err := s.doSync(func() {
+		l = len(s.entries)
+	}, defaultTimeout)
+	return l, err
+}
+
+// Cleanup manually tells the store to cleanup.
+func (s *RateLimitStore) Cleanup() error {
+	return s.doSync(func() {
+		s.cleanup(time.Now())
+	}, defaultTimeout)
+}
+
+// take lets the limiter take one request for the key.
+func (s *RateLimitStore) take(limiter *RateLimitHandler, key string) (rateLimitResult, error) {
+	var result rateLimitResult
+	err := s.doSync(func() {
+		rlk := rateLimitKey{limiter, key}
+		entry, ok := s.entries[rlk]
+		if !ok {
+			if len(s.entries) >= s.maxEntries {
+				s.evict(time.Now())
+			}
+			entry = &rateLimitEntry{}
+			s.entries[rlk] = entry
+		}
+		result = limiter.take(entry, time.Now())
+	}, defaultTimeout)
+	return result, err
+}
+
+// cleanup removes the expired entries.
+func (s *RateLimitStore) cleanup(now time.Time) {
+	for rlk, entry := range s.entries {
+		if !entry.expires.After(now) {
+			delete(s.entries, rlk)
+		}
+	}
+}
+
+// evict removes the expired entries and, if the maximum number is
+// still reached, the ones expiring in the first half of the remaining
+// time. Finally arbitrary entries are removed if needed.
+func (s *RateLimitStore) evict(now time.Time) {
+	s.cleanup(now)
+	if len(s.entries) < s.maxEntries {
+		return
+	}
+	latest := now
+	for _, entry := range s.entries {
+		if entry.expires.After(latest) {
+			latest = entry.expires
+		}
+	}
+	s.cleanup(now.Add(latest.Sub(now) / 2))
+	for rlk := range s.entries {
+		if len(s.entries) < s.maxEntries {
+			return
+		}
+		delete(s.entries, rlk)
+	}
+}
+
+// doSync performs a function in the backend synchronously.
+func (s *RateLimitStore) doSync(action func(), timeout time.Duration) error {
+	donec := make(chan struct{})
+	select {
+	case s.actionc <- func() {
+		action()
+		close(donec)
+	}:
+	case <-s.ctx.Done():
+		return failure.New("rate limit store is stopped")
+	}
+	select {
+	case <-donec:
+		return nil
+	case <-s.ctx.Done():
+		return failure.New("rate limit store is stopped")
+	case <-time.After(timeout):
+		return failure.New("rate limit store action timeout")
+	}
+}
+
+// backend is the goroutine of the store.
+func (s *RateLimitStore) backend() {
+	ticker := time.NewTicker(s.interval)
+	for {
+		select {
+		case <-s.ctx.Done():
+			s.entries = map[rateLimitKey]*rateLimitEntry{}
+			ticker.Stop()
+			return
+		case action := <-s.actionc:
+			action()
+		case <-ticker.C:
+			go func() {
+				if err := s.Cleanup(); err != nil {
+					logger.Errorf("rate limit store: %v", err)
+				}
+			}()
+		}
+	}
+}
+
+// EOF
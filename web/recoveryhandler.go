@@ -0,0 +1,111 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// RECOVERY HANDLER
+//--------------------
+
+// PanicReporter is called by the RecoveryHandler for each recovered
+// panic, e.g. to report it to an error tracking service. It gets the
+// request, the value passed to panic, and the stack trace.
+type PanicReporter func(r *http.Request, v interface{}, stack []byte)
+
+// RecoveryHandlerConfig allows to control how the recovery handler
+// works. All values are optional. Reporter is called for each
+// recovered panic, ErrorRenderer writes the problem details of the
+// response, default is RenderProblem.
+type RecoveryHandlerConfig struct {
+	Reporter      PanicReporter
+	ErrorRenderer ErrorRenderer
+}
+
+// RecoveryHandler recovers panics of the wrapped handler. They are
+// logged with their stack trace and passed to the optional reporter.
+// The caller receives the status code 500 as problem details written
+// by the error renderer, headers set by the wrapped handler are
+// dropped. If the wrapped handler already started the response it
+// cannot be changed anymore and is only finished. Panics with
+// http.ErrAbortHandler are passed on to abort the response silently.
+type RecoveryHandler struct {
+	handler  http.Handler
+	reporter PanicReporter
+	renderer ErrorRenderer
+}
+
+// NewRecoveryHandler creates a handler recovering panics of the
+// given handler.
+func NewRecoveryHandler(handler http.Handler, config *RecoveryHandlerConfig) *RecoveryHandler {
+	if handler == nil {
+		panic("need handler")
+	}
+	rh := &RecoveryHandler{
+		handler: handler,
+	}
+	if config != nil {
+		rh.reporter = config.Reporter
+		rh.renderer = config.ErrorRenderer
+	}
+	return rh
+}
+
+// RecoveryMiddleware returns a middleware wrapping handlers with a
+// RecoveryHandler using the given configuration.
+func RecoveryMiddleware(config *RecoveryHandlerConfig) Middleware {
+	return func(handler http.Handler) http.Handler {
+		return NewRecoveryHandler(handler, config)
+	}
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (rh *RecoveryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sw := &statusResponseWriter{
+		ResponseWriter: w,
+	}
+	header := w.Header().Clone()
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			panic(v)
+		}
+		stack := debug.Stack()
+		logger.Errorf("recovery handler: panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, stack)
+		if rh.reporter != nil {
+			rh.reporter(r, v, stack)
+		}
+		if sw.statusCode != 0 {
+			return
+		}
+		// Drop the headers set by the handler, e.g. Content-Length.
+		h := w.Header()
+		for key := range h {
+			delete(h, key)
+		}
+		for key, values := range header {
+			h[key] = values
+		}
+		writeProblem(rh.renderer, w, r, http.StatusInternalServerError, "")
+	}()
+	rh.handler.ServeHTTP(sw, r)
+}
+
+// EOF
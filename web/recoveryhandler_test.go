@@ -0,0 +1,167 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/net/httpx"
+	"tideland.dev/go/net/web"
+	"tideland.dev/go/trace/logger"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidRecoveryHandler tests the panic if the given handler
+// for the RecoveryHandler is invalid.
+func TestInvalidRecoveryHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+
+	assert.Panics(func() {
+		web.NewRecoveryHandler(nil, nil)
+	}, "need handler")
+}
+
+// TestRecoveryHandler tests the recovering of panics and the
+// negotiation of the response content.
+func TestRecoveryHandler(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	var mu sync.Mutex
+	var reported []interface{}
+	reporter := func(r *http.Request, v interface{}, stack []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Substring("goroutine", string(stack))
+		reported = append(reported, v)
+	}
+	mh := web.NewMethodHandler()
+	mh.HandleFunc(http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+		panic("ouch")
+	})
+	mh.HandleFunc(http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("started"))
+		panic("too late")
+	})
+
+	wa.Handle("/recover/", web.RecoveryMiddleware(&web.RecoveryHandlerConfig{
+		Reporter: reporter,
+	})(mh))
+	wa.Handle("/silent/", web.NewRecoveryHandler(mh, nil))
+
+	tests := []struct {
+		path        string
+		method      string
+		accept      string
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		{"/recover/", http.MethodGet, "", http.StatusInternalServerError, httpx.ContentTypeProblemJSON, `{"instance":"/recover/","status":500,"title":"Internal Server Error"}`},
+		{"/recover/", http.MethodGet, httpx.ContentTypeJSON, http.StatusInternalServerError, httpx.ContentTypeProblemJSON, `{"instance":"/recover/","status":500,"title":"Internal Server Error"}`},
+		{"/recover/", http.MethodGet, httpx.ContentTypeXML, http.StatusInternalServerError, httpx.ContentTypeProblemXML, xml.Header + `<problem xmlns="urn:ietf:rfc:7807"><title>Internal Server Error</title><status>500</status><instance>/recover/</instance></problem>`},
+		{"/recover/", http.MethodPost, "", http.StatusAccepted, environments.ContentTypePlain, "started"},
+		{"/silent/", http.MethodGet, "", http.StatusInternalServerError, httpx.ContentTypeProblemJSON, `{"instance":"/silent/","status":500,"title":"Internal Server Error"}`},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s %s accepting %q", test.method, test.path, test.accept)
+		tw.Reset()
+		wreq := wa.CreateRequest(test.method, test.path)
+		if test.accept != "" {
+			wreq.Header().Set(httpx.HeaderAccept, test.accept)
+		}
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, test.contentType)
+		assert.Equal(string(wresp.Body()), test.body)
+		assert.Equal(tw.Len(), 1)
+		entry := tw.Entries()[0]
+		assert.Substring("recovery handler: panic serving "+test.method+" "+test.path, entry)
+		assert.Substring("goroutine", entry)
+	}
+	assert.Equal(reported, []interface{}{"ouch", "ouch", "ouch", "too late"})
+}
+
+// TestRecoveryHandlerAbort tests the passing of http.ErrAbortHandler.
+func TestRecoveryHandlerAbort(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	reporter := func(r *http.Request, v interface{}, stack []byte) {
+		assert.Fail("abort must not be reported")
+	}
+	wa.Handle("/abort/", web.NewRecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}), &web.RecoveryHandlerConfig{
+		Reporter: reporter,
+	}))
+
+	resp, err := http.Get(wa.URL() + "/abort/")
+	if err == nil {
+		resp.Body.Close()
+	}
+	assert.ErrorMatch(err, ".*EOF.*")
+	for _, entry := range tw.Entries() {
+		assert.False(strings.Contains(entry, "recovery handler"))
+	}
+}
+
+// TestRecoveryHandlerHeaders tests the dropping of headers set by
+// the handler before it panicked.
+func TestRecoveryHandlerHeaders(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	tw := logger.NewTestWriter()
+	ow := logger.SetWriter(tw)
+	defer logger.SetWriter(ow)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	rh := web.NewRecoveryHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4711")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", `"4711"`)
+		panic("ouch")
+	}), nil)
+	wa.Handle("/headers/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Outer", "kept")
+		rh.ServeHTTP(w, r)
+	}))
+
+	wresp := wa.CreateRequest(http.MethodGet, "/headers/").Do()
+	wresp.AssertStatusCodeEquals(http.StatusInternalServerError)
+	wresp.Header().AssertKeyValueEquals(httpx.HeaderContentType, httpx.ContentTypeProblemJSON)
+	wresp.Header().AssertKeyValueEquals("X-Outer", "kept")
+	assertHeaderEquals(assert, wresp, "Content-Encoding", "")
+	assertHeaderEquals(assert, wresp, "ETag", "")
+	assert.Equal(string(wresp.Body()), `{"instance":"/headers/","status":500,"title":"Internal Server Error"}`)
+}
+
+// EOF
@@ -0,0 +1,353 @@
+// Tideland Go Network - Web
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web // import "tideland.dev/go/net/web"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"tideland.dev/go/net/httpx"
+)
+
+//--------------------
+// PARAMETERS
+//--------------------
+
+// Param is a named parameter of a path matched by a Router.
+type Param struct {
+	Name  string
+	Value string
+}
+
+// Params contains the parameters of a matched path in the order
+// of the pattern.
+type Params []Param
+
+// Get returns the value of the named parameter and true if it
+// exists. Otherwise an empty string and false.
+func (ps Params) Get(name string) (string, bool) {
+	for _, p := range ps {
+		if p.Name == name {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// ParamsFromContext returns the parameters of the path matched by
+// a Router, if any.
+func ParamsFromContext(ctx context.Context) (Params, bool) {
+	params, ok := ctx.Value(paramsKey).(Params)
+	return params, ok
+}
+
+// PathParam returns the named parameter of the request path matched
+// by a Router and true if it exists. Otherwise an empty string and
+// false.
+func PathParam(r *http.Request, name string) (string, bool) {
+	params, _ := ParamsFromContext(r.Context())
+	return params.Get(name)
+}
+
+//--------------------
+// ROUTER
+//--------------------
+
+// Router distributes requests depending on their path and method to
+// handlers. Path patterns consist of static segments, parameters
+// like {orderID} matching one segment, wildcards * matching one
+// segment without storing it, and a final catch-all like {path...}
+// matching the rest of the path. So the pattern
+//
+//	/orders/{orderID}/items/{itemID}
+//
+// matches "/orders/1/items/2" with the parameters orderID and itemID.
+// Static segments take precedence over parameters and those over
+// catch-alls. Trailing slashes are significant.
+//
+// Handlers are registered per method like at the MethodHandler,
+// MethodAll is used for all methods not explicitly registered. HEAD
+// and OPTIONS requests are handled automatically, requests for known
+// paths but without handler for the method are answered with status
+// code 405.
+type Router struct {
+	root     *routeNode
+	renderer ErrorRenderer
+}
+
+// NewRouter creates an empty router.
+func NewRouter() *Router {
+	return &Router{
+		root: &routeNode{},
+	}
+}
+
+// Handle adds the handler for method and path pattern.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	if method != MethodAll && !httpx.IsValidMethod(method) {
+		panic("invalid HTTP method")
+	}
+	if handler == nil {
+		panic("need handler")
+	}
+	node := rt.root.insert(pattern)
+	if node.handlers == nil {
+		node.handlers = make(map[string]http.Handler)
+	}
+	if _, exist := node.handlers[method]; exist {
+		panic("multiple registrations for " + method + " " + pattern)
+	}
+	node.handlers[method] = handler
+}
+
+// HandleFunc adds the handler function for method and path pattern.
+func (rt *Router) HandleFunc(method, pattern string, hf func(http.ResponseWriter, *http.Request)) {
+	if hf == nil {
+		panic("need handler function")
+	}
+	rt.Handle(method, pattern, http.HandlerFunc(hf))
+}
+
+// SetErrorRenderer sets the renderer for requests with unknown paths
+// or methods without handler. Passing nil resets it to RenderProblem.
+func (rt *Router) SetErrorRenderer(er ErrorRenderer) {
+	rt.renderer = er
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params Params
+	node := rt.root.match(r.URL.Path, &params)
+	if node == nil {
+		notFound(rt.renderer).ServeHTTP(w, r)
+		return
+	}
+	if len(params) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), paramsKey, params))
+	}
+	serveMethod(w, r, node.handlers, rt.renderer)
+}
+
+//--------------------
+// ROUTE TREE
+//--------------------
+
+// routeNode is a node of the radix tree of patterns. Static nodes
+// contain a part of the path in their prefix, parameter, wildcard,
+// and catch-all nodes the name of their parameter.
+type routeNode struct {
+	prefix   string
+	name     string
+	statics  []*routeNode
+	param    *routeNode
+	catchAll *routeNode
+	handlers map[string]http.Handler
+}
+
+// insert adds the pattern to the tree and returns the node of its end.
+func (n *routeNode) insert(pattern string) *routeNode {
+	node := n
+	for _, part := range parsePattern(pattern) {
+		switch part.kind {
+		case staticPart:
+			node = node.insertStatic(part.text)
+		case paramPart:
+			if node.param == nil {
+				node.param = &routeNode{name: part.text}
+			}
+			if node.param.name != part.text {
+				panic("parameter '" + paramName(part.text) + "' of pattern " + pattern + " conflicts with '" + paramName(node.param.name) + "'")
+			}
+			node = node.param
+		case catchAllPart:
+			if node.catchAll == nil {
+				node.catchAll = &routeNode{name: part.text}
+			}
+			if node.catchAll.name != part.text {
+				panic("parameter '" + part.text + "' of pattern " + pattern + " conflicts with '" + node.catchAll.name + "'")
+			}
+			node = node.catchAll
+		}
+	}
+	return node
+}
+
+// insertStatic adds the static path below the node, splitting the
+// prefixes of existing nodes where needed.
+func (n *routeNode) insertStatic(path string) *routeNode {
+	if path == "" {
+		return n
+	}
+	for _, child := range n.statics {
+		if child.prefix[0] != path[0] {
+			continue
+		}
+		l := commonPrefixLen(child.prefix, path)
+		if l < len(child.prefix) {
+			// Split the child at the common prefix.
+			tail := *child
+			tail.prefix = child.prefix[l:]
+			*child = routeNode{
+				prefix:  child.prefix[:l],
+				statics: []*routeNode{&tail},
+			}
+		}
+		return child.insertStatic(path[l:])
+	}
+	child := &routeNode{prefix: path}
+	n.statics = append(n.statics, child)
+	return child
+}
+
+// match returns the node with handlers matching the rest of the path
+// below the node and collects the parameters. Static nodes are tried
+// first, then parameters, and at last catch-alls.
+func (n *routeNode) match(path string, params *Params) *routeNode {
+	if path == "" && n.handlers != nil {
+		return n
+	}
+	for _, child := range n.statics {
+		if strings.HasPrefix(path, child.prefix) {
+			if node := child.match(path[len(child.prefix):], params); node != nil {
+				return node
+			}
+			break
+		}
+	}
+	if n.param != nil && path != "" && path[0] != '/' {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		l := len(*params)
+		if n.param.name != "" {
+			*params = append(*params, Param{n.param.name, path[:end]})
+		}
+		if node := n.param.match(path[end:], params); node != nil {
+			return node
+		}
+		*params = (*params)[:l]
+	}
+	if n.catchAll != nil && n.catchAll.handlers != nil {
+		*params = append(*params, Param{n.catchAll.name, path})
+		return n.catchAll
+	}
+	return nil
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// patternPartKind describes the kind of a part of a pattern.
+type patternPartKind int
+
+const (
+	staticPart patternPartKind = iota
+	paramPart
+	catchAllPart
+)
+
+// patternPart is a static text or a parameter of a pattern.
+type patternPart struct {
+	kind patternPartKind
+	text string
+}
+
+// parsePattern splits the pattern into static and parameter parts.
+// Wildcards are parameters without name.
+func parsePattern(pattern string) []patternPart {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("pattern " + pattern + " has to start with a slash")
+	}
+	var parts []patternPart
+	names := map[string]bool{}
+	static := "/"
+	flush := func() {
+		if static != "" {
+			parts = append(parts, patternPart{staticPart, static})
+			static = ""
+		}
+	}
+	segments := strings.Split(pattern[1:], "/")
+	for i, segment := range segments {
+		if i > 0 {
+			static += "/"
+		}
+		switch {
+		case segment == "*":
+			flush()
+			parts = append(parts, patternPart{paramPart, ""})
+		case strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}"):
+			name := segment[1 : len(segment)-1]
+			kind := paramPart
+			if strings.HasSuffix(name, "...") {
+				if i != len(segments)-1 {
+					panic("catch-all of pattern " + pattern + " has to be the last segment")
+				}
+				name = strings.TrimSuffix(name, "...")
+				kind = catchAllPart
+			}
+			if !isValidParamName(name) {
+				panic("parameter name '" + name + "' of pattern " + pattern + " is invalid")
+			}
+			if names[name] {
+				panic("parameter name '" + name + "' of pattern " + pattern + " is used twice")
+			}
+			names[name] = true
+			flush()
+			parts = append(parts, patternPart{kind, name})
+		case strings.ContainsAny(segment, "{}*"):
+			panic("segment '" + segment + "' of pattern " + pattern + " is invalid")
+		default:
+			static += segment
+		}
+	}
+	flush()
+	return parts
+}
+
+// paramName returns the name of a parameter for messages.
+func paramName(name string) string {
+	if name == "" {
+		return "*"
+	}
+	return name
+}
+
+// isValidParamName checks if the name only contains letters,
+// digits, and underscores.
+func isValidParamName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// commonPrefixLen returns the length of the common prefix of a and b.
+func commonPrefixLen(a, b string) int {
+	l := 0
+	for l < len(a) && l < len(b) && a[l] == b[l] {
+		l++
+	}
+	return l
+}
+
+// EOF
@@ -0,0 +1,184 @@
+// Tideland Go Network - Web - Unit Tests
+//
+// Copyright (C) 2020 Frank Mueller / Tideland / Oldenburg / Germany
+//
+// All rights reserved. Use of this source code is governed
+// by the new BSD license.
+
+package web_test // import "tideland.dev/go/net/web_test"
+
+//--------------------
+// IMPORTS
+//--------------------
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"tideland.dev/go/audit/asserts"
+	"tideland.dev/go/audit/environments"
+	"tideland.dev/go/net/web"
+)
+
+//--------------------
+// TESTS
+//--------------------
+
+// TestInvalidRouter tests the panics for invalid values passed
+// to a Router.
+func TestInvalidRouter(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	rt := web.NewRouter()
+	echo := makeRouteEcho(assert, "echo")
+
+	tests := []struct {
+		method  string
+		pattern string
+		handler http.HandlerFunc
+		panic   string
+	}{
+		{"DO-SOMETHING", "/", echo, "invalid HTTP method"},
+		{http.MethodGet, "/", nil, "need handler function"},
+		{http.MethodGet, "orders", echo, "pattern orders has to start with a slash"},
+		{http.MethodGet, "/orders/{}", echo, "parameter name '' of pattern /orders/{} is invalid"},
+		{http.MethodGet, "/orders/{order-id}", echo, "parameter name 'order-id' of pattern /orders/{order-id} is invalid"},
+		{http.MethodGet, "/orders/{id}/items/{id}", echo, "parameter name 'id' of pattern /orders/{id}/items/{id} is used twice"},
+		{http.MethodGet, "/files/{path...}/info", echo, "catch-all of pattern /files/{path...}/info has to be the last segment"},
+		{http.MethodGet, "/orders/o{id}", echo, "segment 'o{id}' of pattern /orders/o{id} is invalid"},
+		{http.MethodGet, "/orders/*x", echo, "segment '*x' of pattern /orders/*x is invalid"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s %s", test.method, test.pattern)
+		assert.Panics(func() {
+			rt.HandleFunc(test.method, test.pattern, test.handler)
+		}, test.panic)
+	}
+
+	rt.HandleFunc(http.MethodGet, "/orders/{orderID}", echo)
+	assert.Panics(func() {
+		rt.HandleFunc(http.MethodGet, "/orders/{orderID}", echo)
+	}, "multiple registrations for GET /orders/{orderID}")
+	assert.Panics(func() {
+		rt.HandleFunc(http.MethodGet, "/orders/{id}/items", echo)
+	}, "parameter 'id' of pattern /orders/{id}/items conflicts with 'orderID'")
+}
+
+// TestRouter tests the routing of requests by path and method.
+func TestRouter(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	wa := startWebAsserter(assert)
+	defer wa.Close()
+
+	rt := web.NewRouter()
+	rt.HandleFunc(http.MethodGet, "/", makeRouteEcho(assert, "root"))
+	rt.HandleFunc(http.MethodGet, "/orders", makeRouteEcho(assert, "orders"))
+	rt.HandleFunc(http.MethodPost, "/orders", makeRouteEcho(assert, "create-order"))
+	rt.HandleFunc(http.MethodGet, "/orders/", makeRouteEcho(assert, "orders-slash"))
+	rt.HandleFunc(http.MethodGet, "/orders/new", makeRouteEcho(assert, "new-order"))
+	rt.HandleFunc(http.MethodGet, "/orders/{orderID}", makeRouteEcho(assert, "order"))
+	rt.HandleFunc(http.MethodGet, "/orders/{orderID}/items/{itemID}", makeRouteEcho(assert, "item"))
+	rt.HandleFunc(http.MethodGet, "/orders/new/items", makeRouteEcho(assert, "new-items"))
+	rt.HandleFunc(http.MethodGet, "/ordering", makeRouteEcho(assert, "ordering"))
+	rt.HandleFunc(http.MethodGet, "/foo/{fooID}/bar", makeRouteEcho(assert, "bar"))
+	rt.HandleFunc(http.MethodGet, "/users/*/avatar", makeRouteEcho(assert, "avatar"))
+	rt.HandleFunc(http.MethodGet, "/files/{path...}", makeRouteEcho(assert, "files"))
+	rt.HandleFunc(http.MethodGet, "/files/{name}/info", makeRouteEcho(assert, "file-info"))
+	rt.HandleFunc(web.MethodAll, "/any", makeRouteEcho(assert, "any"))
+	rt.HandleFunc(http.MethodDelete, "/any", makeRouteEcho(assert, "delete-any"))
+
+	wa.Handle("/", rt)
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{http.MethodGet, "/", http.StatusOK, "root"},
+		{http.MethodGet, "/orders", http.StatusOK, "orders"},
+		{http.MethodPost, "/orders", http.StatusOK, "create-order"},
+		{http.MethodGet, "/orders/", http.StatusOK, "orders-slash"},
+		{http.MethodGet, "/orders/new", http.StatusOK, "new-order"},
+		{http.MethodGet, "/orders/1", http.StatusOK, "order orderID=1"},
+		{http.MethodGet, "/orders/newer", http.StatusOK, "order orderID=newer"},
+		{http.MethodGet, "/orders/1/items/2", http.StatusOK, "item orderID=1 itemID=2"},
+		{http.MethodGet, "/orders/new/items", http.StatusOK, "new-items"},
+		{http.MethodGet, "/orders/new/items/3", http.StatusOK, "item orderID=new itemID=3"},
+		{http.MethodGet, "/orders/1/items", http.StatusNotFound, "no matching path handler found"},
+		{http.MethodGet, "/orders/1/items/", http.StatusNotFound, "no matching path handler found"},
+		{http.MethodGet, "/orders//items/2", http.StatusNotFound, "no matching path handler found"},
+		{http.MethodGet, "/ordering", http.StatusOK, "ordering"},
+		{http.MethodGet, "/order", http.StatusNotFound, "no matching path handler found"},
+		{http.MethodGet, "/foo/1/bar", http.StatusOK, "bar fooID=1"},
+		{http.MethodGet, "/foo/1/baz", http.StatusNotFound, "no matching path handler found"},
+		{http.MethodGet, "/users/john/avatar", http.StatusOK, "avatar"},
+		{http.MethodGet, "/files/", http.StatusOK, "files path="},
+		{http.MethodGet, "/files/a/b/c.txt", http.StatusOK, "files path=a/b/c.txt"},
+		{http.MethodGet, "/files/a/info", http.StatusOK, "file-info name=a"},
+		{http.MethodGet, "/files/a/info/more", http.StatusOK, "files path=a/info/more"},
+		{http.MethodPut, "/any", http.StatusOK, "any"},
+		{http.MethodDelete, "/any", http.StatusOK, "delete-any"},
+		{http.MethodPut, "/orders", http.StatusMethodNotAllowed, "no matching method handler found"},
+		{http.MethodHead, "/orders", http.StatusOK, ""},
+		{http.MethodOptions, "/orders", http.StatusNoContent, ""},
+		{http.MethodGet, "/unknown", http.StatusNotFound, "no matching path handler found"},
+	}
+	for _, test := range tests {
+		assert.Logf("testing %s %s", test.method, test.path)
+		wreq := wa.CreateRequest(test.method, test.path)
+		wresp := wreq.Do()
+		wresp.AssertStatusCodeEquals(test.statusCode)
+		wresp.AssertBodyMatches(test.body)
+		if test.statusCode == http.StatusMethodNotAllowed {
+			wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS, POST")
+		}
+		if test.method == http.MethodOptions {
+			wresp.Header().AssertKeyValueEquals("Allow", "GET, HEAD, OPTIONS, POST")
+		}
+	}
+}
+
+// TestParams tests the access to path parameters.
+func TestParams(t *testing.T) {
+	assert := asserts.NewTesting(t, asserts.FailStop)
+	params := web.Params{{"orderID", "1"}, {"itemID", "2"}}
+
+	v, ok := params.Get("itemID")
+	assert.True(ok)
+	assert.Equal(v, "2")
+	v, ok = params.Get("unknown")
+	assert.False(ok)
+	assert.Equal(v, "")
+
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	assert.NoError(err)
+	_, ok = web.ParamsFromContext(r.Context())
+	assert.False(ok)
+	_, ok = web.PathParam(r, "orderID")
+	assert.False(ok)
+}
+
+//--------------------
+// HELPERS
+//--------------------
+
+// makeRouteEcho creates a handler echoing its name and the
+// parameters of the path.
+func makeRouteEcho(assert *asserts.Asserts, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reply := []string{name}
+		params, _ := web.ParamsFromContext(r.Context())
+		for _, param := range params {
+			value, ok := web.PathParam(r, param.Name)
+			assert.True(ok)
+			reply = append(reply, param.Name+"="+value)
+		}
+		w.Header().Add(environments.HeaderContentType, environments.ContentTypePlain)
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(strings.Join(reply, " ")))
+		assert.NoError(err)
+	}
+}
+
+// EOF